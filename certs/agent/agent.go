@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/blend/go-sdk/logger"
+	"github.com/mat285/go-sdk/certs"
+)
+
+var (
+	ErrPeerCredentials = errors.New("signing agent cannot read peer credentials")
+	ErrPeerNotAllowed  = errors.New("signing agent peer user not allowed")
+)
+
+// Agent holds private keys and signs with them for clients on a unix socket,
+// so the serving process never reads the keys.
+//
+// That only holds when the agent runs as a different user than the server,
+// since a process can read the memory of another running as the same user.
+// Run the agent as a user owning the key files, and the server as a user who
+// cannot read them. Let the server connect by listening with a group they
+// share and a mode like 0660, and set PeerUIDs to the server's user.
+type Agent struct {
+	Log certs.Logger
+	// PeerUIDs, if set, are the only users allowed to connect, checked with
+	// SO_PEERCRED. Connections are refused on platforms other than Linux.
+	PeerUIDs []int
+
+	lock sync.RWMutex
+	keys map[string]crypto.Signer
+}
+
+func New(log certs.Logger) *Agent {
+	return &Agent{
+		Log:  log,
+		keys: make(map[string]crypto.Signer),
+	}
+}
+
+// Add adds or replaces a key.
+func (a *Agent) Add(id string, key crypto.Signer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.keys[id] = key
+}
+
+func (a *Agent) Remove(id string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.keys, id)
+}
+
+// Listen listens on a unix socket, removing a stale socket file first. By
+// default only its owner can connect; use certs.OptSocketGroup and
+// certs.OptSocketMode to let a server running as another user connect.
+func Listen(path string, opts ...certs.SocketOption) (net.Listener, error) {
+	return certs.ListenUnix(path, opts...)
+}
+
+// Serve handles clients from the listener until the context is cancelled or
+// accepting fails, closing the listener and client connections before
+// returning.
+func (a *Agent) Serve(ctx context.Context, l net.Listener) error {
+	defer l.Close()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.handle(connCtx, conn)
+		}()
+	}
+}
+
+func (a *Agent) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if err := a.checkPeer(conn); err != nil {
+		logger.MaybeWarningfContext(ctx, a.Log, "Signing agent refused connection: %v", err)
+		return
+	}
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp := a.respond(req)
+		if len(resp.Error) > 0 {
+			logger.MaybeWarningfContext(ctx, a.Log, "Signing agent %s request for key %s failed: %s", req.Op, req.Key, resp.Error)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) checkPeer(conn net.Conn) error {
+	if len(a.PeerUIDs) == 0 {
+		return nil
+	}
+	uid, err := peerUID(conn)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(a.PeerUIDs, uid) {
+		return fmt.Errorf("%w: uid %d", ErrPeerNotAllowed, uid)
+	}
+	return nil
+}
+
+func (a *Agent) respond(req request) response {
+	switch req.Op {
+	case opKeys:
+		return a.listKeys()
+	case opSign:
+		a.lock.RLock()
+		key := a.keys[req.Key]
+		a.lock.RUnlock()
+		if key == nil {
+			return response{Error: ErrKeyNotFound.Error()}
+		}
+		var opts crypto.SignerOpts = req.Hash
+		if req.SaltLength != nil {
+			opts = &rsa.PSSOptions{SaltLength: *req.SaltLength, Hash: req.Hash}
+		}
+		sig, err := key.Sign(rand.Reader, req.Digest, opts)
+		if err != nil {
+			return response{Error: err.Error()}
+		}
+		return response{Signature: sig}
+	default:
+		return response{Error: "unknown op " + req.Op}
+	}
+}
+
+func (a *Agent) listKeys() response {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	resp := response{Keys: make([]keyInfo, 0, len(a.keys))}
+	for id, key := range a.keys {
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			return response{Error: err.Error()}
+		}
+		resp.Keys = append(resp.Keys, keyInfo{ID: id, PublicKey: der})
+	}
+	sort.Slice(resp.Keys, func(i, j int) bool {
+		return resp.Keys[i].ID < resp.Keys[j].ID
+	})
+	return resp
+}
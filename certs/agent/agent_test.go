@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+	"github.com/mat285/go-sdk/certs/certstest"
+)
+
+// startAgent serves the agent on a temp socket until the test finishes.
+func startAgent(t *testing.T, a *Agent) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve = %v", err)
+		}
+	})
+	return path
+}
+
+func TestAgentHandshake(t *testing.T) {
+	ca := certstest.NewCA(t)
+	for _, tc := range []struct {
+		name    string
+		keyID   string
+		opts    []certstest.Option
+		version uint16
+	}{
+		{name: "ecdsa", keyID: "web", version: tls.VersionTLS13},
+		// RSA signs with PSS in TLS 1.3, exercising the salt length
+		{name: "rsa-pss", keyID: "web", opts: []certstest.Option{certstest.OptRSA(2048)}, version: tls.VersionTLS13},
+		{name: "rsa-pkcs1", keyID: "web", opts: []certstest.Option{certstest.OptRSA(2048)}, version: tls.VersionTLS12},
+		// without a Key-Id the key is found by the certificate's public key
+		{name: "by-public-key", version: tls.VersionTLS13},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pair := ca.Issue(t, append([]certstest.Option{certstest.OptDNSNames("web.test")}, tc.opts...)...)
+			a := New(nil)
+			a.Add("web", pair.Key)
+			socket := startAgent(t, a)
+
+			dir := certstest.NewDir(t)
+			dir.Write("web.crt", pair.CertPEM())
+			dir.Write("web.key", certs.EncodeSignerRef("agent", tc.keyID))
+			r, _ := certstest.NewReloader(t, dir, certs.OptReloaderSigner("agent", NewClient(socket)))
+			if leaf := certstest.Serving(r, "web.test"); leaf == nil || !leaf.Equal(pair.Cert) {
+				t.Fatalf("serving %v, want the agent's pair", leaf)
+			}
+
+			server := certs.NewServerTLSConfig(r)
+			server.MaxVersion = tc.version
+			client := &tls.Config{ServerName: "web.test", RootCAs: ca.Pool()}
+			if err := handshake(server, client); err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+		})
+	}
+}
+
+func handshake(server, client *tls.Config) error {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	done := make(chan error, 1)
+	go func() { done <- tls.Server(s, server).Handshake() }()
+	if err := tls.Client(c, client).Handshake(); err != nil {
+		return err
+	}
+	return <-done
+}
+
+func TestAgentUnknownKey(t *testing.T) {
+	ca := certstest.NewCA(t)
+	a := New(nil)
+	a.Add("web", ca.Issue(t).Key)
+	c := NewClient(startAgent(t, a))
+	if _, err := c.Signer(certs.SignerRef{KeyID: "api"}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown key ID: %v, want %v", err, ErrKeyNotFound)
+	}
+	other := ca.Issue(t)
+	if _, err := c.Signer(certs.SignerRef{PublicKey: other.Key.Public()}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown public key: %v, want %v", err, ErrKeyNotFound)
+	}
+
+	a.Remove("web")
+	if keys, err := c.Keys(); err != nil || len(keys) != 0 {
+		t.Errorf("keys after removal = %v, %v", keys, err)
+	}
+}
+
+func TestAgentPeerUIDs(t *testing.T) {
+	key := certstest.NewCA(t).Issue(t).Key
+	for _, tc := range []struct {
+		uid     int
+		allowed bool
+	}{
+		{uid: os.Getuid(), allowed: true},
+		{uid: os.Getuid() + 1},
+	} {
+		a := New(nil)
+		a.Add("web", key)
+		a.PeerUIDs = []int{tc.uid}
+		keys, err := NewClient(startAgent(t, a)).Keys()
+		if tc.allowed && (err != nil || len(keys) != 1) {
+			t.Errorf("peer uid %d allowed: keys = %v, %v", tc.uid, keys, err)
+		}
+		if !tc.allowed && err == nil {
+			t.Errorf("agent answered uid %d not in PeerUIDs", os.Getuid())
+		}
+	}
+}
+
+type failingListener struct {
+	net.Listener
+	fail chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.fail:
+		return nil, errors.New("accept failed")
+	default:
+		return l.Listener.Accept()
+	}
+}
+
+func TestServeAcceptErrorClosesClients(t *testing.T) {
+	inner, err := Listen(filepath.Join(t.TempDir(), "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &failingListener{Listener: inner, fail: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- New(nil).Serve(context.Background(), l) }()
+
+	conn, err := net.Dial("unix", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a round trip makes sure the client is being handled
+	if err := json.NewEncoder(conn).Encode(request{Op: opKeys}); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(conn).Decode(&response{}); err != nil {
+		t.Fatal(err)
+	}
+	close(l.fail)
+	// unblock the pending Accept so it sees the failure
+	wake, err := net.Dial("unix", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wake.Close()
+
+	select {
+	case err := <-done:
+		if err == nil || errors.Is(err, context.Canceled) {
+			t.Errorf("Serve = %v, want the accept error", err)
+		}
+	case <-time.After(certstest.DefaultWaitTimeout):
+		t.Fatal("Serve waited for a connected client after accept failed")
+	}
+}
+
+func TestListenGroupMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := Listen(path, certs.OptSocketGroup(os.Getgid()), certs.OptSocketMode(0660))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0660 {
+		t.Errorf("socket mode = %v, want 0660", perm)
+	}
+}
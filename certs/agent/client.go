@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+)
+
+var (
+	ErrKeyNotFound = errors.New("signing agent has no such key")
+)
+
+// Client is a certs.SignerProvider backed by a signing agent listening on a
+// unix socket. Keys are referenced by Key-Id, or found by the certificate's
+// public key when the reference has none.
+type Client struct {
+	Socket  string
+	Timeout time.Duration
+}
+
+var _ certs.SignerProvider = (*Client)(nil)
+
+func NewClient(socket string) *Client {
+	return &Client{Socket: socket}
+}
+
+// Keys returns the agent's public keys by key ID.
+func (c *Client) Keys() (map[string]crypto.PublicKey, error) {
+	resp, err := c.call(request{Op: opKeys})
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(resp.Keys))
+	for _, key := range resp.Keys {
+		pub, err := x509.ParsePKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing agent key %s: %w", key.ID, err)
+		}
+		keys[key.ID] = pub
+	}
+	return keys, nil
+}
+
+func (c *Client) Signer(ref certs.SignerRef) (crypto.Signer, error) {
+	keys, err := c.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if len(ref.KeyID) > 0 {
+		pub, has := keys[ref.KeyID]
+		if !has {
+			return nil, fmt.Errorf("%w %q", ErrKeyNotFound, ref.KeyID)
+		}
+		return &signer{client: c, id: ref.KeyID, public: pub}, nil
+	}
+	if ref.PublicKey == nil {
+		return nil, fmt.Errorf("%s: no %s header", ref.KeyFile, certs.SignerHeaderKeyID)
+	}
+	for id, pub := range keys {
+		if equal, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); ok && equal.Equal(ref.PublicKey) {
+			return &signer{client: c, id: id, public: pub}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w matching the certificate", ErrKeyNotFound)
+}
+
+// call sends a request on a new connection, so a restarted agent is picked up
+// without reconnecting.
+func (c *Client) call(req request) (response, error) {
+	timeout := c.timeout()
+	conn, err := net.DialTimeout("unix", c.Socket, timeout)
+	if err != nil {
+		return response{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return response{}, err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return response{}, err
+	}
+	var resp response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return response{}, err
+	}
+	if len(resp.Error) > 0 {
+		return response{}, fmt.Errorf("signing agent: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+type signer struct {
+	client *Client
+	id     string
+	public crypto.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := request{Op: opSign, Key: s.id, Digest: digest, Hash: opts.HashFunc()}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		saltLength := pss.SaltLength
+		req.SaltLength = &saltLength
+	}
+	resp, err := s.client.call(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
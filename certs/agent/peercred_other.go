@@ -0,0 +1,13 @@
+//go:build !linux
+
+package agent
+
+import (
+	"net"
+)
+
+// peerUID is only supported on Linux, so agents restricted to peer users
+// refuse every connection elsewhere.
+func peerUID(net.Conn) (int, error) {
+	return -1, ErrPeerCredentials
+}
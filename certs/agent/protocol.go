@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"crypto"
+)
+
+const (
+	opKeys = "keys"
+	opSign = "sign"
+)
+
+// request is a newline delimited JSON request. Byte slices are base64.
+type request struct {
+	Op     string      `json:"op"`
+	Key    string      `json:"key,omitempty"`
+	Digest []byte      `json:"digest,omitempty"`
+	Hash   crypto.Hash `json:"hash,omitempty"`
+	// SaltLength is set for RSA-PSS signatures.
+	SaltLength *int `json:"saltLength,omitempty"`
+}
+
+type response struct {
+	Keys      []keyInfo `json:"keys,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type keyInfo struct {
+	ID string `json:"id"`
+	// PublicKey is PKIX DER.
+	PublicKey []byte `json:"publicKey"`
+}
@@ -0,0 +1,177 @@
+package certs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+// AuditEntry is one JSON line of the audit log.
+type AuditEntry struct {
+	Time                time.Time `json:"time"`
+	Action              EventType `json:"action"`
+	Trigger             Trigger   `json:"trigger"`
+	Name                string    `json:"name"`
+	SANs                []string  `json:"sans"`
+	Serial              string    `json:"serial"`
+	Fingerprint         string    `json:"fingerprint"`
+	Issuer              string    `json:"issuer"`
+	NotBefore           time.Time `json:"notBefore"`
+	NotAfter            time.Time `json:"notAfter"`
+	PreviousFingerprint string    `json:"previousFingerprint,omitempty"`
+}
+
+func NewAuditEntry(e Event) AuditEntry {
+	entry := AuditEntry{
+		Time:                e.Time.UTC(),
+		Action:              e.Type,
+		Trigger:             e.Trigger,
+		Name:                e.Name,
+		Fingerprint:         e.Cert.Fingerprint(),
+		PreviousFingerprint: e.Previous.Fingerprint(),
+	}
+	if leaf := e.Cert.Leaf; leaf != nil {
+		entry.SANs = sansOf(leaf)
+		entry.Serial = leaf.SerialNumber.Text(16)
+		entry.Issuer = leaf.Issuer.String()
+		entry.NotBefore = leaf.NotBefore.UTC()
+		entry.NotAfter = leaf.NotAfter.UTC()
+	}
+	return entry
+}
+
+// AuditLog writes an AuditEntry line for every cache event.
+type AuditLog struct {
+	lock sync.Mutex
+	log  Logger
+	out  io.Writer
+}
+
+func NewAuditLog(out io.Writer, log Logger) *AuditLog {
+	return &AuditLog{out: out, log: log}
+}
+
+func (a *AuditLog) Handle(e Event) {
+	if e.Cert == nil {
+		return
+	}
+	line, err := json.Marshal(NewAuditEntry(e))
+	if err != nil {
+		a.error(err)
+		return
+	}
+	line = append(line, '\n')
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.out.Write(line); err != nil {
+		a.error(err)
+	}
+}
+
+func (a *AuditLog) error(err error) {
+	logger.MaybeErrorf(a.log, "Error writing cert audit log: %v", err)
+}
+
+// RotatingFile is an append only file that is rotated to `<path>.1` through
+// `<path>.<MaxBackups>` once it reaches MaxSize bytes. Each write is synced
+// to disk unless NoSync is set, and the file is always synced before it is
+// rotated or closed.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	NoSync     bool
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if err != nil || rf.NoSync {
+		return n, err
+	}
+	return n, rf.file.Sync()
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := errors.Join(rf.file.Sync(), rf.file.Close())
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = stat.Size()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	err := errors.Join(rf.file.Sync(), rf.file.Close())
+	rf.file = nil
+	if err != nil {
+		return err
+	}
+	if rf.MaxBackups <= 0 {
+		if err := os.Remove(rf.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+	for i := rf.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(rf.backup(i), rf.backup(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.Path, rf.backup(1)); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", rf.Path, i)
+}
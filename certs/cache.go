@@ -1,48 +1,99 @@
 package certs
 
 import (
-	"maps"
+	"os"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blend/go-sdk/logger"
 )
 
+const (
+	DefaultEvictDelay = 2 * time.Second
+)
+
 type Cache struct {
+	// HistoryLimit is the number of versions retained per name.
+	HistoryLimit int
+	Clock        Clock
+	// EvictDelay is how long a pair's files must stay missing before it is
+	// evicted, so rotations that remove files before writing them do not
+	// drop the cert. Defaults to DefaultEvictDelay.
+	EvictDelay time.Duration
+
 	lock     sync.Mutex
 	log      Logger
+	loader   *Loader
 	certs    map[string]*Cert
-	sni      map[string]*Cert
+	sni      atomic.Pointer[map[string][]*Cert]
+	spiffe   atomic.Pointer[map[string]*Cert]
 	modified map[string]*Cert
+	staged   map[string]*Cert
+	timers   map[string]Timer
+	evicting map[string]Timer
+	history  map[string][]CertVersion
+	pinned   map[string]int
+	handlers []EventHandler
+	pending  []Event
 }
 
 func NewCache(log Logger) *Cache {
 	return &Cache{
 		log:      log,
 		certs:    make(map[string]*Cert),
-		sni:      make(map[string]*Cert),
 		modified: make(map[string]*Cert),
+		staged:   make(map[string]*Cert),
+		timers:   make(map[string]Timer),
+		evicting: make(map[string]Timer),
+		history:  make(map[string][]CertVersion),
+		pinned:   make(map[string]int),
 	}
 }
 
 func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return len(c.certs)
 }
 
 func (c *Cache) GetSNI(dnsName string) *Cert {
-	sni := c.sni
-	if sni == nil {
+	return c.GetSNIForProtos(dnsName, nil)
+}
+
+// GetSNIForProtos returns the highest precedence cert for the name that may
+// be served to a client offering the ALPN protocols.
+func (c *Cache) GetSNIForProtos(dnsName string, protos []string) *Cert {
+	snip := c.sni.Load()
+	if snip == nil {
 		return nil
 	}
-	cert, has := sni[dnsName]
-	if has {
-		return cert
+	sni := *snip
+	for _, name := range SNICandidates(dnsName) {
+		if cert := firstAllowed(sni[name], protos); cert != nil {
+			return cert
+		}
 	}
-	wildcard := WildcardFor(dnsName)
-	if len(wildcard) == 0 {
+	return nil
+}
+
+func firstAllowed(certs []*Cert, protos []string) *Cert {
+	for _, cert := range certs {
+		if cert.Metadata.AllowsALPN(protos) {
+			return cert
+		}
+	}
+	return nil
+}
+
+func (c *Cache) GetSPIFFE(id string) *Cert {
+	ids := c.spiffe.Load()
+	if ids == nil {
 		return nil
 	}
-	return sni[wildcard]
+	return (*ids)[id]
 }
 
 func (c *Cache) Get(name string) *Cert {
@@ -51,6 +102,22 @@ func (c *Cache) Get(name string) *Cert {
 	return c.certs[name]
 }
 
+func (c *Cache) All() []*Cert {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret := make([]*Cert, 0, len(c.certs))
+	for _, cert := range c.certs {
+		ret = append(ret, cert)
+	}
+	return ret
+}
+
+func (c *Cache) Staged(name string) *Cert {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.staged[name]
+}
+
 func (c *Cache) SetModified(file string, mod time.Time) {
 	name, ft := FilePairNameAndType(file)
 	cert := c.Get(name)
@@ -77,46 +144,324 @@ func (c *Cache) PopModified() []string {
 }
 
 func (c *Cache) Reload(name string) (bool, error) {
+	return c.reload(name, TriggerManual)
+}
+
+func (c *Cache) reload(name string, trigger Trigger) (bool, error) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	defer c.unlock()
 	var err error
 	var added bool
-	cert := c.certs[name]
-	if cert != nil {
+	var cert *Cert
+	if existing := c.latest(name); existing != nil {
+		if len(existing.Source) > 0 {
+			return false, nil
+		}
 		added = false
-		err = cert.Reload()
+		mod := existing.Loaded
+		if sidecarRemoved(existing) {
+			mod = time.Time{}
+		}
+		cert, err = c.loader.LoadCertFiles(existing.Name, existing.CertFile.Path, existing.KeyFile.Path, mod)
+		if os.IsNotExist(err) {
+			c.evictLater(name, trigger)
+		}
 	} else {
 		added = true
-		cert, err = LoadCertPair(name, time.Time{})
+		cert, err = c.loader.LoadCertPair(name, time.Time{})
 	}
 	if err != nil {
 		return false, err
 	}
-	c.set(cert)
+	c.set(trigger, cert)
 	return added, nil
 }
 
 func (c *Cache) Set(certs ...*Cert) {
+	c.setWithTrigger(TriggerManual, certs...)
+}
+
+func (c *Cache) setWithTrigger(trigger Trigger, certs ...*Cert) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.set(certs...)
+	defer c.unlock()
+	c.set(trigger, certs...)
+}
+
+// Evict removes the cert and any staged replacement from the cache.
+func (c *Cache) Evict(name string) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.evict(name, TriggerManual)
+}
+
+func (c *Cache) evict(name string, trigger Trigger) {
+	c.cancelEvict(name)
+	c.unstage(name)
+	delete(c.history, name)
+	delete(c.pinned, name)
+	cert := c.certs[name]
+	if cert == nil {
+		return
+	}
+	logger.MaybeInfof(c.log, "Evicting cert %s", name)
+	delete(c.certs, name)
+	c.reindex()
+	c.record(EventEvict, trigger, cert, nil)
+}
+
+// evictMissing evicts certs whose files no longer exist once the files have
+// stayed missing for the evict delay.
+func (c *Cache) evictMissing(trigger Trigger) {
+	c.lock.Lock()
+	defer c.unlock()
+	for name, cert := range c.certs {
+		if c.filesMissing(cert) {
+			c.evictLater(name, trigger)
+		}
+	}
 }
 
-func (c *Cache) set(certs ...*Cert) {
+func (c *Cache) filesMissing(cert *Cert) bool {
+	if len(cert.Source) > 0 {
+		return false
+	}
+	return !fileExists(cert.CertFile.Path) || !fileExists(cert.KeyFile.Path)
+}
+
+// evictLater evicts the cert after the evict delay if its files are still
+// missing then.
+func (c *Cache) evictLater(name string, trigger Trigger) {
+	if _, has := c.evicting[name]; has {
+		return
+	}
+	logger.MaybeDebugf(c.log, "Files for cert %s are missing, evicting in %v unless they return", name, c.evictDelay())
+	c.evicting[name] = c.clock().AfterFunc(c.evictDelay(), func() {
+		c.lock.Lock()
+		defer c.unlock()
+		delete(c.evicting, name)
+		if cert := c.latest(name); cert != nil && c.filesMissing(cert) {
+			c.evict(name, trigger)
+		}
+	})
+}
+
+func (c *Cache) cancelEvict(name string) {
+	if timer, has := c.evicting[name]; has {
+		timer.Stop()
+		delete(c.evicting, name)
+	}
+}
+
+func (c *Cache) evictDelay() time.Duration {
+	if c.EvictDelay > 0 {
+		return c.EvictDelay
+	}
+	return DefaultEvictDelay
+}
+
+func (c *Cache) latest(name string) *Cert {
+	if staged := c.staged[name]; staged != nil {
+		return staged
+	}
+	if versions := c.history[name]; len(versions) > 0 {
+		return versions[len(versions)-1].Cert
+	}
+	return c.certs[name]
+}
+
+func (c *Cache) set(trigger Trigger, certs ...*Cert) {
+	now := c.now()
+	changed := false
 	for _, cert := range certs {
 		if cert == nil {
 			continue
 		}
-		dnsNames := cert.DNSNames()
-		logger.MaybeDebugf(c.log, "Setting cert name to cache %s, DNSNames: %v", cert.Name, dnsNames)
-		c.certs[cert.Name] = cert
+		if cert.NotYetValid(now) {
+			c.stage(cert, now)
+			continue
+		}
+		if staged := c.staged[cert.Name]; staged != nil && cert.modified().After(staged.modified()) {
+			logger.MaybeDebugf(c.log, "Discarding staged cert %s replaced by a newer valid cert", cert.Name)
+			c.unstage(cert.Name)
+		}
+		c.install(cert, trigger)
+		changed = true
+	}
+	if changed {
+		c.reindex()
+	}
+}
+
+// modified is when the cert's contents last changed, the cert file's mod time
+// for pairs and the load time for certs from sources, which have no files.
+func (c *Cert) modified() time.Time {
+	if len(c.Source) > 0 {
+		return c.Loaded
+	}
+	return c.CertFile.Mod
+}
+
+func (c *Cache) stage(cert *Cert, now time.Time) {
+	notBefore := cert.Leaf.NotBefore
+	if prev := c.staged[cert.Name]; prev != nil && prev.Leaf.NotBefore.Equal(notBefore) {
+		c.staged[cert.Name] = cert
+		return
+	}
+	logger.MaybeInfof(c.log, "Staging cert %s until %s", cert.Name, notBefore.Format(time.RFC3339))
+	c.unstage(cert.Name)
+	c.staged[cert.Name] = cert
+	name := cert.Name
+	c.timers[name] = c.clock().AfterFunc(notBefore.Sub(now), func() { c.promote(name) })
+}
+
+func (c *Cache) unstage(name string) {
+	if timer := c.timers[name]; timer != nil {
+		timer.Stop()
+	}
+	delete(c.timers, name)
+	delete(c.staged, name)
+}
+
+func (c *Cache) promote(name string) {
+	c.lock.Lock()
+	defer c.unlock()
+	cert := c.staged[name]
+	if cert == nil {
+		return
+	}
+	now := c.now()
+	if cert.NotYetValid(now) {
+		delete(c.staged, name)
+		c.stage(cert, now)
+		return
+	}
+	logger.MaybeInfof(c.log, "Promoting staged cert %s", name)
+	c.unstage(name)
+	c.install(cert, TriggerStaged)
+	c.reindex()
+}
+
+// install adds the cert to the history and serves it unless the name is
+// pinned, without reindexing.
+func (c *Cache) install(cert *Cert, trigger Trigger) {
+	version := c.remember(cert)
+	if pinned, has := c.pinned[cert.Name]; has {
+		if version != pinned {
+			logger.MaybeInfof(c.log, "Holding cert %s at pinned version %d, not serving version %d", cert.Name, pinned, version)
+		}
+		return
+	}
+	c.serve(cert, trigger)
+}
+
+// serve sets the cert without reindexing, recording an event if the served
+// certificate changed or was disabled or enabled.
+func (c *Cache) serve(cert *Cert, trigger Trigger) {
+	logger.MaybeDebugf(c.log, "Setting cert name to cache %s, DNSNames: %v", cert.Name, cert.Hostnames())
+	prev := c.certs[cert.Name]
+	if prev == nil || prev.Fingerprint() != cert.Fingerprint() || !reflect.DeepEqual(prev.Metadata, cert.Metadata) {
+		c.warnUnserved(cert)
+	}
+	c.certs[cert.Name] = cert
+	switch {
+	case prev == nil:
+		c.record(EventAdd, trigger, cert, nil)
+	case prev.Fingerprint() != cert.Fingerprint(), prev.Metadata.IsDisabled() != cert.Metadata.IsDisabled():
+		c.record(EventReplace, trigger, cert, prev)
+	}
+}
+
+// warnUnserved warns about the cert's names that are not served. Reloads
+// replace certs with unchanged copies, so it is only called for new contents.
+func (c *Cache) warnUnserved(cert *Cert) {
+	warned := make(map[string]bool)
+	for _, name := range cert.Hostnames() {
+		name = NormalizeHostname(name)
+		if warned[name] {
+			continue
+		}
+		if err := CheckWildcard(name); err != nil {
+			warned[name] = true
+			logger.MaybeWarningf(c.log, "Not serving cert %s for %s: %v", cert.Name, name, err)
+		}
+	}
+	for _, alias := range cert.InvalidAliases() {
+		logger.MaybeWarningf(c.log, "Not serving cert %s for alias %s: the certificate is not valid for it", cert.Name, alias)
+	}
+}
+
+// reindex rebuilds the name and SPIFFE ID indexes, ordering the certs for each
+// name by precedence. The maps are replaced rather than modified to allow
+// readers to keep accessing them without the lock.
+func (c *Cache) reindex() {
+	sni := make(map[string][]*Cert, len(c.certs))
+	ids := make(map[string]*Cert)
+	for _, cert := range c.certs {
+		if cert.Metadata.IsDisabled() {
+			continue
+		}
+		for _, name := range servedNames(cert) {
+			sni[name] = append(sni[name], cert)
+		}
+		if id := cert.SPIFFEID(); len(id) > 0 {
+			if prev := ids[id]; prev == nil || precedes(cert, prev) {
+				ids[id] = cert
+			}
+		}
+	}
+	for _, certs := range sni {
+		sort.Slice(certs, func(i, j int) bool {
+			return precedes(certs[i], certs[j])
+		})
+	}
+	c.sni.Store(&sni)
+	c.spiffe.Store(&ids)
+}
+
+// sidecarRemoved reports whether a metadata or SCT file the cert was loaded
+// with is gone, which mod times alone cannot show.
+func sidecarRemoved(cert *Cert) bool {
+	for _, f := range []File{cert.MetadataFile, cert.SCTFile} {
+		if len(f.Path) > 0 && !fileExists(f.Path) {
+			return true
+		}
+	}
+	return false
+}
 
-		// copy map to allow readers to keep accessing without lock
-		sni := make(map[string]*Cert, len(c.sni))
-		maps.Copy(sni, c.sni)
-		for _, dn := range dnsNames {
-			sni[dn] = cert
+// servedNames returns the normalized hostnames of the cert, without
+// duplicates or wildcards that must not be served.
+func servedNames(cert *Cert) []string {
+	hostnames := cert.Hostnames()
+	names := make([]string, 0, len(hostnames))
+	seen := make(map[string]bool, len(hostnames))
+	for _, name := range hostnames {
+		name = NormalizeHostname(name)
+		if seen[name] || CheckWildcard(name) != nil {
+			continue
 		}
-		c.sni = sni
+		seen[name] = true
+		names = append(names, name)
 	}
+	return names
+}
+
+// precedes orders certs claiming the same name by metadata priority, then
+// the latest expiry, then name.
+func precedes(a, b *Cert) bool {
+	if pa, pb := a.Metadata.GetPriority(), b.Metadata.GetPriority(); pa != pb {
+		return pa > pb
+	}
+	if a.Leaf != nil && b.Leaf != nil && !a.Leaf.NotAfter.Equal(b.Leaf.NotAfter) {
+		return a.Leaf.NotAfter.After(b.Leaf.NotAfter)
+	}
+	return a.Name < b.Name
+}
+
+func (c *Cache) clock() Clock {
+	return clockOrSystem(c.Clock)
+}
+
+func (c *Cache) now() time.Time {
+	return c.clock().Now()
 }
@@ -4,19 +4,37 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
-	"io/fs"
-	"os"
+	"net"
 	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	ErrInvalidWildcard = errors.New("invalid wildcard")
+	ErrBroadWildcard   = errors.New("wildcard covers a public suffix")
 )
 
 type Cert struct {
-	Name     string
-	CertFile File
-	KeyFile  File
-	Loaded   time.Time
+	Name         string
+	CertFile     File
+	KeyFile      File
+	MetadataFile File
+	Metadata     *Metadata
+	SCTFile      File
+	// Source names the Source that supplied the cert, and is empty for pairs
+	// loaded from the reloader's directories.
+	Source string
+	Loaded time.Time
 	tls.Certificate
+
+	loader *Loader
 }
 
 func (c *Cert) DNSNames() []string {
@@ -34,14 +52,71 @@ func (c *Cert) DNSNames() []string {
 			return nil
 		}
 	}
-	return append(c.Certificate.Leaf.DNSNames, c.Certificate.Leaf.Subject.CommonName)
+	names := make([]string, 0, len(c.Certificate.Leaf.DNSNames)+1)
+	names = append(names, c.Certificate.Leaf.DNSNames...)
+	return append(names, c.Certificate.Leaf.Subject.CommonName)
+}
+
+// Hostnames returns the names the cert is served for, its DNS names plus the
+// aliases from its metadata that the certificate is valid for.
+func (c *Cert) Hostnames() []string {
+	names := c.DNSNames()
+	if c != nil && c.Metadata != nil {
+		for _, alias := range c.Metadata.Aliases {
+			if c.validFor(alias) {
+				names = append(names, alias)
+			}
+		}
+	}
+	return names
+}
+
+// InvalidAliases returns the aliases from the cert's metadata that the
+// certificate is not valid for, which are not served.
+func (c *Cert) InvalidAliases() []string {
+	if c == nil || c.Metadata == nil {
+		return nil
+	}
+	var invalid []string
+	for _, alias := range c.Metadata.Aliases {
+		if !c.validFor(alias) {
+			invalid = append(invalid, alias)
+		}
+	}
+	return invalid
+}
+
+// validFor reports whether a client would accept the certificate for the
+// name, which may be a wildcard the certificate also names.
+func (c *Cert) validFor(name string) bool {
+	names := c.DNSNames()
+	if len(names) == 0 {
+		return false
+	}
+	name = NormalizeHostname(name)
+	for _, dnsName := range names {
+		if NormalizeHostname(dnsName) == name {
+			return true
+		}
+	}
+	return !strings.Contains(name, "*") && c.Leaf.VerifyHostname(name) == nil
+}
+
+// NotYetValid reports whether the certificate's NotBefore is after now, in
+// which case the reloader stages it rather than serving it. Only loaders with
+// StageNotYetValid load such certs.
+func (c *Cert) NotYetValid(now time.Time) bool {
+	if c == nil || c.Leaf == nil {
+		return false
+	}
+	return now.Before(c.Leaf.NotBefore)
 }
 
 func (c *Cert) Reload() error {
 	if c == nil {
 		return fmt.Errorf("nil cert")
 	}
-	nc, err := LoadCertPair(c.Name, c.Loaded)
+	nc, err := c.loader.LoadCertFiles(c.Name, c.CertFile.Path, c.KeyFile.Path, c.Loaded)
 	if err != nil {
 		return err
 	}
@@ -55,134 +130,124 @@ func (c *Cert) Reload() error {
 
 func (c *Cert) File(ft FileType) *File {
 	switch ft {
-	case FileTypeCert:
+	case FileTypeCert, FileTypePKCS12:
 		return &c.CertFile
 	case FileTypeKey:
 		return &c.KeyFile
+	case FileTypeMetadata:
+		return &c.MetadataFile
+	case FileTypeSCT:
+		return &c.SCTFile
 	default:
 		return nil
 	}
 }
 
+// WildcardFor returns the wildcard name matching the left-most label of the
+// hostname, or an empty string for IP addresses and single-label names.
 func WildcardFor(name string) string {
-	for i := 0; i < len(name); i++ {
-		if name[i] == '.' {
-			return "*" + name[i:]
-		}
+	if net.ParseIP(name) != nil {
+		return ""
 	}
-	return ""
-}
-
-func FilePairNameAndType(file string) (string, FileType) {
-	ext := filepath.Ext(file)
-	switch ext {
-	case ".crt", ".key":
-		name := file[:len(file)-len(ext)]
-		t := FileType(ext[1:])
-		return name, t
-	default:
-		return "", FileTypeUnknown
+	i := strings.IndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 {
+		return ""
 	}
+	return "*" + name[i:]
 }
 
-func FilePairName(file string) string {
-	name, _ := FilePairNameAndType(file)
-	return name
+// SNICandidates returns the names a cert or route must be indexed under to
+// match the server name, in order of preference.
+func SNICandidates(serverName string) []string {
+	serverName = NormalizeHostname(serverName)
+	if wildcard := WildcardFor(serverName); len(wildcard) > 0 {
+		return []string{serverName, wildcard}
+	}
+	return []string{serverName}
 }
 
-func LoadCertPair(name string, mod time.Time) (*Cert, error) {
-	certFile := name + ".crt"
-	keyFile := name + ".key"
+var hostnameProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.BidiRule())
 
-	cStat, err := os.Stat(certFile)
-	if err != nil {
-		return nil, err
+// NormalizeHostname lowercases the name, strips a trailing dot and converts
+// internationalized labels to punycode, keeping a leading wildcard label.
+func NormalizeHostname(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if isASCII(name) {
+		return strings.ToLower(name)
 	}
-	kStat, err := os.Stat(keyFile)
-	if err != nil {
-		return nil, err
+	prefix := ""
+	if strings.HasPrefix(name, "*.") {
+		prefix, name = "*.", name[2:]
 	}
-
-	if mod.After(cStat.ModTime()) && mod.After(kStat.ModTime()) {
-		return nil, nil
+	ascii, err := hostnameProfile.ToASCII(name)
+	if err != nil {
+		return prefix + strings.ToLower(name)
 	}
+	return prefix + ascii
+}
 
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return nil, err
+// CheckWildcard returns an error if the name is a wildcard that must not be
+// served. RFC 6125 wildcards are a whole left-most label and, to avoid
+// covering unrelated domains, may not sit directly on a public suffix such
+// as com or co.uk.
+func CheckWildcard(name string) error {
+	if !strings.Contains(name, "*") {
+		return nil
 	}
-	if len(cert.Certificate) == 0 {
-		return nil, fmt.Errorf("no certs parsed")
+	if !strings.HasPrefix(name, "*.") || strings.Contains(name[2:], "*") {
+		return fmt.Errorf("%w: %s", ErrInvalidWildcard, name)
 	}
-	xcert, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		return nil, err
+	base := name[2:]
+	if suffix, _ := publicsuffix.PublicSuffix(base); !strings.Contains(base, ".") || suffix == base {
+		return fmt.Errorf("%w: %s", ErrBroadWildcard, name)
 	}
-	cert.Leaf = xcert
+	return nil
+}
 
-	now := time.Now()
-	if now.After(cert.Leaf.NotAfter) || now.Before(cert.Leaf.NotBefore) {
-		return nil, fmt.Errorf("invalid cert parsed")
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
 	}
-
-	return &Cert{
-		Certificate: cert,
-		Name:        name,
-		CertFile: File{
-			Path: certFile,
-			Mod:  cStat.ModTime(),
-		},
-		KeyFile: File{
-			Path: keyFile,
-			Mod:  kStat.ModTime(),
-		},
-		Loaded: time.Now(),
-	}, nil
+	return true
 }
 
-func LoadDirectoryCerts(ctx context.Context, dir string) ([]*Cert, error) {
-	files := map[string]bool{}
-	err := filepath.WalkDir(dir, func(path string, info fs.DirEntry, err error) error {
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return err
-			}
-			return nil
-		}
+// FilePairNameAndType returns the pair name and type of the file by its
+// extension. Extensions are matched case-sensitively, as the loader only
+// probes lowercase extensions.
+func FilePairNameAndType(file string) (string, FileType) {
+	ext := filepath.Ext(file)
+	name := file[:len(file)-len(ext)]
+	switch ext {
+	case ".crt", ".cer", ".der":
+		return name, FileTypeCert
+	case ".key":
+		return name, FileTypeKey
+	case ".p12", ".pfx":
+		return name, FileTypePKCS12
+	case ".yaml", ".yml", ".json":
+		return name, FileTypeMetadata
+	case sctFileExt:
+		return name, FileTypeSCT
+	default:
+		return "", FileTypeUnknown
+	}
+}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+func FilePairName(file string) string {
+	name, _ := FilePairNameAndType(file)
+	return name
+}
 
-		if info.IsDir() {
-			return nil
-		}
-		name := FilePairName(path)
-		if len(name) == 0 {
-			return nil
-		}
-		files[name] = true
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
+func LoadCertPair(name string, mod time.Time) (*Cert, error) {
+	return (*Loader)(nil).LoadCertPair(name, mod)
+}
 
-	certs := make([]*Cert, 0, len(files))
-	for name := range files {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-		}
+func LoadCertFiles(name, certFile, keyFile string, mod time.Time) (*Cert, error) {
+	return (*Loader)(nil).LoadCertFiles(name, certFile, keyFile, mod)
+}
 
-		cert, err := LoadCertPair(name, time.Time{})
-		if err != nil || cert == nil {
-			continue
-		}
-		certs = append(certs, cert)
-	}
-	return certs, nil
+func LoadDirectoryCerts(ctx context.Context, dir string) ([]*Cert, error) {
+	return (*Loader)(nil).LoadDirectoryCerts(ctx, dir)
 }
@@ -0,0 +1,85 @@
+package certs_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+func TestNormalizeHostname(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{name: "web.example.com", want: "web.example.com"},
+		{name: "Web.EXAMPLE.com.", want: "web.example.com"},
+		{name: "*.Example.com", want: "*.example.com"},
+		{name: "bücher.example", want: "xn--bcher-kva.example"},
+		{name: "BÜCHER.example.", want: "xn--bcher-kva.example"},
+		{name: "*.bücher.example", want: "*.xn--bcher-kva.example"},
+		{name: "10.0.0.1", want: "10.0.0.1"},
+		{name: "", want: ""},
+	} {
+		if got := certs.NormalizeHostname(tc.name); got != tc.want {
+			t.Errorf("NormalizeHostname(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckWildcard(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{name: "web.example.com"},
+		{name: "*.example.com"},
+		{name: "*.web.example.co.uk"},
+		{name: "*.example.co.uk"},
+		{name: "*", err: certs.ErrInvalidWildcard},
+		{name: "w*.example.com", err: certs.ErrInvalidWildcard},
+		{name: "web.*.example.com", err: certs.ErrInvalidWildcard},
+		{name: "*.*.example.com", err: certs.ErrInvalidWildcard},
+		{name: "*.com", err: certs.ErrBroadWildcard},
+		{name: "*.co.uk", err: certs.ErrBroadWildcard},
+		{name: "*.localhost", err: certs.ErrBroadWildcard},
+	} {
+		if err := certs.CheckWildcard(tc.name); !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Errorf("CheckWildcard(%q) = %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestWildcardFor(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{name: "web.example.com", want: "*.example.com"},
+		{name: "a.web.example.com", want: "*.web.example.com"},
+		{name: "example.com", want: "*.com"},
+		{name: "localhost", want: ""},
+		{name: ".example.com", want: ""},
+		{name: "web.", want: ""},
+		{name: "10.0.0.1", want: ""},
+		{name: "::1", want: ""},
+	} {
+		if got := certs.WildcardFor(tc.name); got != tc.want {
+			t.Errorf("WildcardFor(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSNICandidates(t *testing.T) {
+	for _, tc := range []struct {
+		serverName string
+		want       []string
+	}{
+		{serverName: "Web.Example.com.", want: []string{"web.example.com", "*.example.com"}},
+		{serverName: "localhost", want: []string{"localhost"}},
+		{serverName: "10.0.0.1", want: []string{"10.0.0.1"}},
+	} {
+		if got := certs.SNICandidates(tc.serverName); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SNICandidates(%q) = %v, want %v", tc.serverName, got, tc.want)
+		}
+	}
+}
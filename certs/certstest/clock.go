@@ -0,0 +1,150 @@
+package certstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+// FakeClock is a certs.Clock whose time only moves when advanced. Timers and
+// tickers that come due are fired by Advance in order.
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+var _ certs.Clock = (*FakeClock)(nil)
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+type waiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	f      func()
+	c      chan time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward, firing timers and ticks as it passes
+// them. Timer funcs run synchronously; ticks are dropped if the previous one
+// was not received, like time.Ticker.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	target := c.now.Add(d)
+	c.lock.Unlock()
+	for c.fireNext(target) {
+	}
+	c.lock.Lock()
+	c.now = target
+	c.lock.Unlock()
+}
+
+func (c *FakeClock) fireNext(target time.Time) bool {
+	c.lock.Lock()
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].at.Before(c.waiters[j].at)
+	})
+	if len(c.waiters) == 0 || c.waiters[0].at.After(target) {
+		c.lock.Unlock()
+		return false
+	}
+	w := c.waiters[0]
+	c.now = w.at
+	if w.period > 0 {
+		w.at = w.at.Add(w.period)
+	} else {
+		c.waiters = c.waiters[1:]
+	}
+	now := c.now
+	c.lock.Unlock()
+
+	if w.f != nil {
+		w.f()
+		return true
+	}
+	select {
+	case w.c <- now:
+	default:
+	}
+	return true
+}
+
+// Waiters returns the number of pending timers and tickers, which lets tests
+// wait for a goroutine to start its ticker before advancing.
+func (c *FakeClock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.waiters)
+}
+
+// Tickers returns the number of running tickers and how many of them have a
+// tick that has not been received.
+func (c *FakeClock) Tickers() (running, pending int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, w := range c.waiters {
+		if w.c != nil {
+			running++
+			if len(w.c) > 0 {
+				pending++
+			}
+		}
+	}
+	return running, pending
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) certs.Ticker {
+	if d <= 0 {
+		panic("certstest: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(&waiter{period: d, c: make(chan time.Time, 1)}, d)}
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) certs.Timer {
+	return c.add(&waiter{f: f}, d)
+}
+
+func (c *FakeClock) add(w *waiter, d time.Duration) *waiter {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w.clock = c
+	w.at = c.now.Add(d)
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+type fakeTicker struct {
+	*waiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t fakeTicker) Stop() {
+	t.waiter.Stop()
+}
+
+func (w *waiter) Stop() bool {
+	c := w.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
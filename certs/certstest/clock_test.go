@@ -0,0 +1,56 @@
+package certstest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	var fired []time.Duration
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, c.Now().Sub(start)) })
+	c.AfterFunc(time.Second, func() { fired = append(fired, c.Now().Sub(start)) })
+	stopped := c.AfterFunc(time.Second, func() { t.Error("stopped timer fired") })
+	if !stopped.Stop() {
+		t.Error("Stop on a pending timer returned false")
+	}
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+	if n := c.Waiters(); n != 3 {
+		t.Errorf("waiters = %d, want 3", n)
+	}
+
+	c.Advance(1500 * time.Millisecond)
+	if want := []time.Duration{time.Second}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired = %v, want %v", fired, want)
+	}
+	if got := c.Now(); !got.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Errorf("now = %v after advancing", got)
+	}
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(time.Second)) {
+			t.Errorf("tick at %v, want %v", tick, start.Add(time.Second))
+		}
+	default:
+		t.Error("ticker did not tick")
+	}
+
+	// ticks are dropped while the previous one is unreceived
+	c.Advance(3 * time.Second)
+	if want := []time.Duration{time.Second, 2 * time.Second}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired = %v, want %v", fired, want)
+	}
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("ticker buffered more than one tick")
+	default:
+	}
+	if n := c.Waiters(); n != 1 {
+		t.Errorf("waiters = %d, want only the ticker", n)
+	}
+}
@@ -0,0 +1,116 @@
+package certstest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Dir is a temporary directory of cert pairs named <name>.crt and <name>.key.
+type Dir struct {
+	Path string
+	t    testing.TB
+}
+
+func NewDir(t testing.TB) *Dir {
+	t.Helper()
+	return &Dir{Path: t.TempDir(), t: t}
+}
+
+// Name returns the cert name the reloader uses for the pair.
+func (d *Dir) Name(name string) string {
+	return filepath.Join(d.Path, name)
+}
+
+func (d *Dir) CertFile(name string) string {
+	return d.Name(name) + ".crt"
+}
+
+func (d *Dir) KeyFile(name string) string {
+	return d.Name(name) + ".key"
+}
+
+// WritePair writes the pair in place.
+func (d *Dir) WritePair(name string, pair *Pair) {
+	d.t.Helper()
+	d.write(d.KeyFile(name), pair.KeyPEM())
+	d.write(d.CertFile(name), pair.CertPEM())
+}
+
+// Write writes a file in the directory, such as a metadata sidecar.
+func (d *Dir) Write(file string, data []byte) {
+	d.t.Helper()
+	d.write(filepath.Join(d.Path, file), data)
+}
+
+// Rotate replaces the pair by renaming fully written files over it, the key
+// first, as a careful deployment tool would.
+func (d *Dir) Rotate(name string, pair *Pair) {
+	d.t.Helper()
+	d.rename(d.KeyFile(name), pair.KeyPEM())
+	d.rename(d.CertFile(name), pair.CertPEM())
+}
+
+// TornRotate starts replacing the pair in place, leaving a truncated cert
+// and the old key on disk, and returns a func that completes the rotation.
+func (d *Dir) TornRotate(name string, pair *Pair) (finish func()) {
+	d.t.Helper()
+	certPEM := pair.CertPEM()
+	d.write(d.CertFile(name), certPEM[:len(certPEM)/2])
+	return func() {
+		d.t.Helper()
+		d.write(d.CertFile(name), certPEM)
+		d.write(d.KeyFile(name), pair.KeyPEM())
+	}
+}
+
+func (d *Dir) Remove(name string) {
+	d.t.Helper()
+	for _, file := range []string{d.CertFile(name), d.KeyFile(name)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			d.t.Fatalf("certstest: %v", err)
+		}
+	}
+}
+
+// Touch sets the modification time of the pair's files.
+func (d *Dir) Touch(name string, mod time.Time) {
+	d.t.Helper()
+	for _, file := range []string{d.CertFile(name), d.KeyFile(name)} {
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			d.t.Fatalf("certstest: %v", err)
+		}
+	}
+}
+
+func (d *Dir) write(path string, data []byte) {
+	d.t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		d.t.Fatalf("certstest: %v", err)
+	}
+}
+
+func (d *Dir) rename(path string, data []byte) {
+	d.t.Helper()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		d.t.Fatalf("certstest: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	// file system timestamps can lag the clock, which would let the reloader
+	// take the new file for one it already loaded
+	if now := time.Now(); err == nil {
+		err = os.Chtimes(tmp.Name(), now, now)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		d.t.Fatalf("certstest: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package certstest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	DefaultValidity = 24 * time.Hour
+)
+
+// KeyType selects the key generated for a certificate.
+type KeyType string
+
+const (
+	KeyTypeECDSA KeyType = "ecdsa"
+	KeyTypeRSA   KeyType = "rsa"
+)
+
+// Spec describes a certificate to generate. NotBefore defaults to an hour
+// before Now and NotAfter to DefaultValidity after NotBefore.
+type Spec struct {
+	CommonName  string
+	DNSNames    []string
+	URIs        []string
+	IPAddresses []string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	KeyType     KeyType
+	RSABits     int
+	ExtKeyUsage []x509.ExtKeyUsage
+	Now         func() time.Time
+	// Key is used instead of generating a key, as when a cert is renewed
+	// with its existing key.
+	Key crypto.Signer
+}
+
+type Option func(*Spec)
+
+func OptCommonName(cn string) Option {
+	return func(s *Spec) {
+		s.CommonName = cn
+	}
+}
+
+func OptDNSNames(names ...string) Option {
+	return func(s *Spec) {
+		s.DNSNames = append(s.DNSNames, names...)
+	}
+}
+
+func OptURIs(uris ...string) Option {
+	return func(s *Spec) {
+		s.URIs = append(s.URIs, uris...)
+	}
+}
+
+func OptIPAddresses(ips ...string) Option {
+	return func(s *Spec) {
+		s.IPAddresses = append(s.IPAddresses, ips...)
+	}
+}
+
+func OptValidity(notBefore, notAfter time.Time) Option {
+	return func(s *Spec) {
+		s.NotBefore = notBefore
+		s.NotAfter = notAfter
+	}
+}
+
+func OptRSA(bits int) Option {
+	return func(s *Spec) {
+		s.KeyType = KeyTypeRSA
+		s.RSABits = bits
+	}
+}
+
+func OptExtKeyUsage(usages ...x509.ExtKeyUsage) Option {
+	return func(s *Spec) {
+		s.ExtKeyUsage = usages
+	}
+}
+
+func OptKey(key crypto.Signer) Option {
+	return func(s *Spec) {
+		s.Key = key
+	}
+}
+
+// OptNow sets the time validity defaults are relative to.
+func OptNow(now func() time.Time) Option {
+	return func(s *Spec) {
+		s.Now = now
+	}
+}
+
+// Pair is a generated certificate, its key and the chain up to but not
+// including the root.
+type Pair struct {
+	Cert  *x509.Certificate
+	Key   crypto.Signer
+	Chain []*x509.Certificate
+}
+
+// CertPEM returns the leaf followed by its chain.
+func (p *Pair) CertPEM() []byte {
+	var out []byte
+	for _, cert := range append([]*x509.Certificate{p.Cert}, p.Chain...) {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+func (p *Pair) KeyPEM() []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(p.Key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func (p *Pair) TLSCertificate() tls.Certificate {
+	cert := tls.Certificate{
+		Certificate: [][]byte{p.Cert.Raw},
+		PrivateKey:  p.Key,
+		Leaf:        p.Cert,
+	}
+	for _, c := range p.Chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert
+}
+
+// CA is a generated certificate authority.
+type CA struct {
+	Pair
+
+	// issued is the chain sent after certs the CA issues, the CA itself
+	// followed by its chain for intermediates.
+	issued []*x509.Certificate
+}
+
+// NewCA generates a self-signed root CA.
+func NewCA(t testing.TB, opts ...Option) *CA {
+	t.Helper()
+	spec := newSpec(opts...)
+	if len(spec.CommonName) == 0 {
+		spec.CommonName = "certstest CA"
+	}
+	tmpl := spec.template()
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	key := spec.generateKey(t)
+	return &CA{Pair: *sign(t, tmpl, tmpl, key, key)}
+}
+
+// Pool returns a pool containing only the CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// Issue generates a leaf signed by the CA. The common name defaults to the
+// first DNS name, and the ext key usage to server and client auth.
+func (ca *CA) Issue(t testing.TB, opts ...Option) *Pair {
+	t.Helper()
+	spec := newSpec(opts...)
+	tmpl := spec.template()
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	pair := sign(t, tmpl, ca.Cert, spec.generateKey(t), ca.Key)
+	pair.Chain = append([]*x509.Certificate(nil), ca.issued...)
+	return pair
+}
+
+// IssueIntermediate generates an intermediate CA signed by the CA.
+func (ca *CA) IssueIntermediate(t testing.TB, opts ...Option) *CA {
+	t.Helper()
+	spec := newSpec(opts...)
+	if len(spec.CommonName) == 0 {
+		spec.CommonName = "certstest intermediate CA"
+	}
+	tmpl := spec.template()
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	pair := sign(t, tmpl, ca.Cert, spec.generateKey(t), ca.Key)
+	pair.Chain = append([]*x509.Certificate(nil), ca.issued...)
+	return &CA{
+		Pair:   *pair,
+		issued: append([]*x509.Certificate{pair.Cert}, ca.issued...),
+	}
+}
+
+// SelfSigned generates a self-signed leaf.
+func SelfSigned(t testing.TB, opts ...Option) *Pair {
+	t.Helper()
+	spec := newSpec(opts...)
+	tmpl := spec.template()
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	key := spec.generateKey(t)
+	return sign(t, tmpl, tmpl, key, key)
+}
+
+func newSpec(opts ...Option) *Spec {
+	spec := &Spec{Now: time.Now}
+	for _, opt := range opts {
+		opt(spec)
+	}
+	if spec.NotBefore.IsZero() {
+		spec.NotBefore = spec.Now().Add(-time.Hour)
+	}
+	if spec.NotAfter.IsZero() {
+		spec.NotAfter = spec.NotBefore.Add(DefaultValidity)
+	}
+	if len(spec.CommonName) == 0 && len(spec.DNSNames) > 0 {
+		spec.CommonName = spec.DNSNames[0]
+	}
+	if len(spec.ExtKeyUsage) == 0 {
+		spec.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	return spec
+}
+
+func (s *Spec) template() *x509.Certificate {
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: s.CommonName},
+		DNSNames:    s.DNSNames,
+		NotBefore:   s.NotBefore,
+		NotAfter:    s.NotAfter,
+		ExtKeyUsage: s.ExtKeyUsage,
+	}
+	for _, raw := range s.URIs {
+		if u, err := url.Parse(raw); err == nil {
+			tmpl.URIs = append(tmpl.URIs, u)
+		}
+	}
+	for _, raw := range s.IPAddresses {
+		if ip := net.ParseIP(raw); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		}
+	}
+	return tmpl
+}
+
+func (s *Spec) generateKey(t testing.TB) crypto.Signer {
+	t.Helper()
+	if s.Key != nil {
+		return s.Key
+	}
+	var key crypto.Signer
+	var err error
+	switch s.KeyType {
+	case KeyTypeRSA:
+		bits := s.RSABits
+		if bits == 0 {
+			bits = 2048
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("certstest: generating key: %v", err)
+	}
+	return key
+}
+
+func sign(t testing.TB, tmpl, parent *x509.Certificate, key crypto.Signer, parentKey crypto.Signer) *Pair {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		t.Fatalf("certstest: generating serial: %v", err)
+	}
+	tmpl.SerialNumber = serial
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("certstest: creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("certstest: parsing certificate: %v", err)
+	}
+	return &Pair{Cert: cert, Key: key}
+}
@@ -0,0 +1,77 @@
+package certstest
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestIssueChain(t *testing.T) {
+	root := NewCA(t)
+	inter := root.IssueIntermediate(t)
+	pair := inter.Issue(t, OptDNSNames("web.test", "www.web.test"))
+
+	if pair.Cert.Subject.CommonName != "web.test" {
+		t.Errorf("common name = %q, want the first DNS name", pair.Cert.Subject.CommonName)
+	}
+	if len(pair.Chain) != 1 || !pair.Chain[0].Equal(inter.Cert) {
+		t.Fatalf("chain = %v, want the intermediate", pair.Chain)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range pair.Chain {
+		intermediates.AddCert(cert)
+	}
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		_, err := pair.Cert.Verify(x509.VerifyOptions{
+			DNSName:       "www.web.test",
+			Roots:         root.Pool(),
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		})
+		if err != nil {
+			t.Errorf("verifying for %v: %v", usage, err)
+		}
+	}
+
+	cert, err := tls.X509KeyPair(pair.CertPEM(), pair.KeyPEM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Errorf("PEM chain has %d certs, want 2", len(cert.Certificate))
+	}
+	if got := pair.TLSCertificate(); len(got.Certificate) != 2 || got.Leaf != pair.Cert {
+		t.Errorf("TLSCertificate = %d certs, leaf %v", len(got.Certificate), got.Leaf)
+	}
+}
+
+func TestIssueOptions(t *testing.T) {
+	ca := NewCA(t)
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pair := ca.Issue(t, OptCommonName("client"), OptRSA(2048), OptNow(func() time.Time { return now }), OptExtKeyUsage(x509.ExtKeyUsageClientAuth))
+	if _, ok := pair.Key.(*rsa.PrivateKey); !ok {
+		t.Errorf("key = %T, want RSA", pair.Key)
+	}
+	if want := now.Add(-time.Hour); !pair.Cert.NotBefore.Equal(want) || !pair.Cert.NotAfter.Equal(want.Add(DefaultValidity)) {
+		t.Errorf("validity = %v to %v, want from %v for %v", pair.Cert.NotBefore, pair.Cert.NotAfter, want, DefaultValidity)
+	}
+	if len(pair.Cert.ExtKeyUsage) != 1 || pair.Cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("ext key usage = %v, want client auth", pair.Cert.ExtKeyUsage)
+	}
+
+	renewed := ca.Issue(t, OptDNSNames("web.test"), OptKey(pair.Key))
+	if renewed.Key != pair.Key || renewed.Cert.Equal(pair.Cert) {
+		t.Error("OptKey did not issue a new cert for the existing key")
+	}
+
+	self := SelfSigned(t, OptURIs("spiffe://example.org/web"), OptIPAddresses("127.0.0.1"))
+	if err := self.Cert.CheckSignature(self.Cert.SignatureAlgorithm, self.Cert.RawTBSCertificate, self.Cert.Signature); err != nil {
+		t.Errorf("self-signed cert: %v", err)
+	}
+	if len(self.Cert.URIs) != 1 || len(self.Cert.IPAddresses) != 1 {
+		t.Errorf("SANs = %v %v", self.Cert.URIs, self.Cert.IPAddresses)
+	}
+}
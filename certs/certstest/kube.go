@@ -0,0 +1,427 @@
+package certstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// KubeSecret is a secret held by a KubeAPI.
+type KubeSecret struct {
+	Namespace   string
+	Name        string
+	Type        string
+	Labels      map[string]string
+	Annotations map[string]string
+	Data        map[string][]byte
+
+	resourceVersion int
+}
+
+// TLSSecret returns a kubernetes.io/tls secret holding the pair.
+func TLSSecret(namespace, name string, pair *Pair) KubeSecret {
+	return KubeSecret{
+		Namespace: namespace,
+		Name:      name,
+		Type:      "kubernetes.io/tls",
+		Data: map[string][]byte{
+			"tls.crt": pair.CertPEM(),
+			"tls.key": pair.KeyPEM(),
+		},
+	}
+}
+
+func (s *KubeSecret) MarshalJSON() ([]byte, error) {
+	type meta struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+	}
+	return json.Marshal(struct {
+		Kind     string            `json:"kind"`
+		Metadata meta              `json:"metadata"`
+		Type     string            `json:"type"`
+		Data     map[string][]byte `json:"data,omitempty"`
+	}{
+		Kind: "Secret",
+		Metadata: meta{
+			Name:            s.Name,
+			Namespace:       s.Namespace,
+			ResourceVersion: strconv.Itoa(s.resourceVersion),
+			Labels:          s.Labels,
+			Annotations:     s.Annotations,
+		},
+		Type: s.Type,
+		Data: s.Data,
+	})
+}
+
+type kubeEvent struct {
+	resourceVersion int
+	old, new        *KubeSecret
+	bookmark        bool
+}
+
+// KubeAPI is a fake Kubernetes API server that lists and watches secrets,
+// supporting namespaces, the type field selector, equality and existence
+// label selectors, paging, watch timeouts, bookmarks and expired resource
+// versions.
+type KubeAPI struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	token     string
+	rv        int
+	compacted int
+	secrets   map[string]*KubeSecret
+	events    []kubeEvent
+	changed   chan struct{}
+	closed    chan struct{}
+	closes    int
+	requests  []string
+}
+
+func NewKubeAPI(t testing.TB) *KubeAPI {
+	t.Helper()
+	k := &KubeAPI{
+		rv:      1,
+		secrets: make(map[string]*KubeSecret),
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	k.Server = httptest.NewServer(http.HandlerFunc(k.serve))
+	t.Cleanup(k.Close)
+	return k
+}
+
+// Put creates or replaces a secret.
+func (k *KubeAPI) Put(secret KubeSecret) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	key := secret.Namespace + "/" + secret.Name
+	k.rv++
+	secret.resourceVersion = k.rv
+	k.events = append(k.events, kubeEvent{resourceVersion: k.rv, old: k.secrets[key], new: &secret})
+	k.secrets[key] = &secret
+	k.notify()
+}
+
+func (k *KubeAPI) Delete(namespace, name string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	key := namespace + "/" + name
+	old := k.secrets[key]
+	if old == nil {
+		return
+	}
+	delete(k.secrets, key)
+	k.rv++
+	k.events = append(k.events, kubeEvent{resourceVersion: k.rv, old: old})
+	k.notify()
+}
+
+// Bookmark sends the current resource version to watches that allow
+// bookmarks, as the API server does periodically.
+func (k *KubeAPI) Bookmark() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.events = append(k.events, kubeEvent{resourceVersion: k.rv, bookmark: true})
+	k.notify()
+}
+
+// Compact discards the event history, so watches from earlier resource
+// versions fail with 410 Gone, and ends open watches.
+func (k *KubeAPI) Compact() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.compacted = k.rv
+	k.events = nil
+	k.closes++
+	close(k.closed)
+	k.closed = make(chan struct{})
+}
+
+// CloseWatches ends open watches as the API server does on its timeout,
+// after sending them the events so far.
+func (k *KubeAPI) CloseWatches() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.closes++
+	close(k.closed)
+	k.closed = make(chan struct{})
+}
+
+// RequireToken sets the bearer token requests must present, rejecting
+// requests with 401 Unauthorized until they do.
+func (k *KubeAPI) RequireToken(token string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.token = token
+}
+
+// Close ends open watches and shuts down the server.
+func (k *KubeAPI) Close() {
+	k.CloseWatches()
+	k.Server.Close()
+}
+
+// Requests returns the request URIs served so far.
+func (k *KubeAPI) Requests() []string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return append([]string(nil), k.requests...)
+}
+
+func (k *KubeAPI) notify() {
+	close(k.changed)
+	k.changed = make(chan struct{})
+}
+
+func (k *KubeAPI) serve(w http.ResponseWriter, req *http.Request) {
+	k.lock.Lock()
+	k.requests = append(k.requests, req.URL.RequestURI())
+	token := k.token
+	k.lock.Unlock()
+	if len(token) > 0 && req.Header.Get("Authorization") != "Bearer "+token {
+		writeKubeStatus(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	namespace, ok := secretsPath(req.URL.Path)
+	if !ok || req.Method != http.MethodGet {
+		writeKubeStatus(w, http.StatusNotFound, "NotFound")
+		return
+	}
+	f := kubeFilter{namespace: namespace}
+	query := req.URL.Query()
+	if sel := query.Get("fieldSelector"); len(sel) > 0 {
+		typ, ok := strings.CutPrefix(sel, "type=")
+		if !ok {
+			writeKubeStatus(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		f.secretType = typ
+	}
+	f.labels = parseLabelSelector(query.Get("labelSelector"))
+	if query.Get("watch") == "true" || query.Get("watch") == "1" {
+		timeout, _ := strconv.Atoi(query.Get("timeoutSeconds"))
+		bookmarks := query.Get("allowWatchBookmarks") == "true"
+		k.watch(w, req, f, query.Get("resourceVersion"), time.Duration(timeout)*time.Second, bookmarks)
+		return
+	}
+	k.list(w, f, query.Get("limit"), query.Get("continue"))
+}
+
+func (k *KubeAPI) list(w http.ResponseWriter, f kubeFilter, limit, cont string) {
+	k.lock.Lock()
+	items := make([]*KubeSecret, 0, len(k.secrets))
+	for _, secret := range k.secrets {
+		if f.matches(secret) {
+			items = append(items, secret)
+		}
+	}
+	rv := k.rv
+	k.lock.Unlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Namespace+"/"+items[i].Name < items[j].Namespace+"/"+items[j].Name
+	})
+	offset, _ := strconv.Atoi(cont)
+	items = items[min(offset, len(items)):]
+	next := ""
+	if n, _ := strconv.Atoi(limit); n > 0 && n < len(items) {
+		items = items[:n]
+		next = strconv.Itoa(offset + n)
+	}
+	writeKubeJSON(w, http.StatusOK, map[string]any{
+		"kind":     "SecretList",
+		"metadata": map[string]string{"resourceVersion": strconv.Itoa(rv), "continue": next},
+		"items":    items,
+	})
+}
+
+func (k *KubeAPI) watch(w http.ResponseWriter, req *http.Request, f kubeFilter, resourceVersion string, timeout time.Duration, bookmarks bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	cursor, _ := strconv.Atoi(resourceVersion)
+	closes := -1
+	for closing := false; ; {
+		k.lock.Lock()
+		if closes < 0 {
+			closes = k.closes
+		}
+		// a close between iterations replaced the channel this one waited on
+		closing = closing || k.closes != closes
+		if cursor < k.compacted {
+			k.lock.Unlock()
+			if !closing {
+				enc.Encode(map[string]any{"type": "ERROR", "object": kubeStatus(http.StatusGone, "Expired")})
+			}
+			return
+		}
+		var pending []kubeEvent
+		for _, e := range k.events {
+			if e.resourceVersion > cursor {
+				pending = append(pending, e)
+			}
+		}
+		changed, closed := k.changed, k.closed
+		k.lock.Unlock()
+
+		for _, e := range pending {
+			cursor = e.resourceVersion
+			if e.bookmark {
+				if bookmarks {
+					enc.Encode(map[string]any{"type": "BOOKMARK", "object": map[string]any{
+						"kind":     "Secret",
+						"metadata": map[string]string{"resourceVersion": strconv.Itoa(cursor)},
+					}})
+				}
+			} else if typ, obj := f.event(e); len(typ) > 0 {
+				enc.Encode(map[string]any{"type": typ, "object": obj})
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if closing {
+			return
+		}
+		select {
+		case <-changed:
+		case <-closed:
+			closing = true
+		case <-expired:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+type kubeFilter struct {
+	namespace  string
+	secretType string
+	labels     []labelRequirement
+}
+
+func (f kubeFilter) matches(secret *KubeSecret) bool {
+	if secret == nil {
+		return false
+	}
+	if len(f.namespace) > 0 && secret.Namespace != f.namespace {
+		return false
+	}
+	if len(f.secretType) > 0 && secret.Type != f.secretType {
+		return false
+	}
+	for _, req := range f.labels {
+		if !req.matches(secret.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// event returns the watch event for the change as seen through the filter,
+// deleting secrets that stop matching and adding ones that start to.
+func (f kubeFilter) event(e kubeEvent) (string, *KubeSecret) {
+	was, is := f.matches(e.old), f.matches(e.new)
+	switch {
+	case was && is:
+		return "MODIFIED", e.new
+	case is:
+		return "ADDED", e.new
+	case was:
+		deleted := *e.old
+		deleted.resourceVersion = e.resourceVersion
+		return "DELETED", &deleted
+	default:
+		return "", nil
+	}
+}
+
+type labelRequirement struct {
+	key      string
+	value    string
+	op       string
+	hasValue bool
+}
+
+func parseLabelSelector(selector string) []labelRequirement {
+	var reqs []labelRequirement
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		switch {
+		case len(term) == 0:
+		case strings.Contains(term, "!="):
+			k, v, _ := strings.Cut(term, "!=")
+			reqs = append(reqs, labelRequirement{key: k, value: v, op: "!=", hasValue: true})
+		case strings.Contains(term, "=="):
+			k, v, _ := strings.Cut(term, "==")
+			reqs = append(reqs, labelRequirement{key: k, value: v, op: "=", hasValue: true})
+		case strings.Contains(term, "="):
+			k, v, _ := strings.Cut(term, "=")
+			reqs = append(reqs, labelRequirement{key: k, value: v, op: "=", hasValue: true})
+		case strings.HasPrefix(term, "!"):
+			reqs = append(reqs, labelRequirement{key: term[1:], op: "!"})
+		default:
+			reqs = append(reqs, labelRequirement{key: term, op: "exists"})
+		}
+	}
+	return reqs
+}
+
+func (r labelRequirement) matches(labels map[string]string) bool {
+	v, has := labels[r.key]
+	switch r.op {
+	case "=":
+		return has && v == r.value
+	case "!=":
+		return !has || v != r.value
+	case "!":
+		return !has
+	default:
+		return has
+	}
+}
+
+func secretsPath(path string) (string, bool) {
+	if path == "/api/v1/secrets" {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(path, "/api/v1/namespaces/")
+	if !ok {
+		return "", false
+	}
+	namespace, ok := strings.CutSuffix(rest, "/secrets")
+	return namespace, ok && len(namespace) > 0 && !strings.Contains(namespace, "/")
+}
+
+func kubeStatus(code int, reason string) map[string]any {
+	return map[string]any{"kind": "Status", "status": "Failure", "code": code, "reason": reason, "message": strings.ToLower(reason)}
+}
+
+func writeKubeStatus(w http.ResponseWriter, code int, reason string) {
+	writeKubeJSON(w, code, kubeStatus(code, reason))
+}
+
+func writeKubeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
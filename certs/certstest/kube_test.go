@@ -0,0 +1,151 @@
+package certstest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type kubeList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+		Continue        string `json:"continue"`
+	} `json:"metadata"`
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+func getKube(t *testing.T, k *KubeAPI, uri string, v any) {
+	t.Helper()
+	resp, err := http.Get(k.URL + uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", uri, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubeAPIList(t *testing.T) {
+	ca := NewCA(t)
+	k := NewKubeAPI(t)
+	for _, name := range []string{"a", "b", "c"} {
+		secret := TLSSecret("web", name, ca.Issue(t, OptDNSNames(name+".test")))
+		secret.Labels = map[string]string{"tier": "edge"}
+		k.Put(secret)
+	}
+	other := TLSSecret("web", "internal", ca.Issue(t))
+	other.Labels = map[string]string{"tier": "internal"}
+	k.Put(other)
+	k.Put(KubeSecret{Namespace: "web", Name: "opaque", Type: "Opaque"})
+	k.Put(TLSSecret("api", "d", ca.Issue(t)))
+
+	var names []string
+	uri := "/api/v1/namespaces/web/secrets?fieldSelector=type%3Dkubernetes.io%2Ftls&labelSelector=tier%3Dedge&limit=2"
+	for cont := ""; ; {
+		var list kubeList
+		getKube(t, k, uri+"&continue="+cont, &list)
+		for _, item := range list.Items {
+			names = append(names, item.Metadata.Name)
+		}
+		if cont = list.Metadata.Continue; len(cont) == 0 {
+			break
+		}
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %v, want %v", names, want)
+	}
+
+	k.RequireToken("secret")
+	resp, err := http.Get(k.URL + "/api/v1/secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("list without token: %s", resp.Status)
+	}
+}
+
+func TestKubeAPIWatch(t *testing.T) {
+	ca := NewCA(t)
+	k := NewKubeAPI(t)
+	secret := TLSSecret("web", "a", ca.Issue(t))
+	secret.Labels = map[string]string{"tier": "edge"}
+	k.Put(secret)
+	var list kubeList
+	getKube(t, k, "/api/v1/secrets?labelSelector=tier", &list)
+
+	resp, err := http.Get(k.URL + "/api/v1/secrets?watch=true&labelSelector=tier&resourceVersion=" + list.Metadata.ResourceVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewScanner(resp.Body)
+	next := func() (string, string) {
+		t.Helper()
+		if !events.Scan() {
+			t.Fatalf("watch ended: %v", events.Err())
+		}
+		var e struct {
+			Type   string `json:"type"`
+			Object struct {
+				Metadata struct {
+					Name string `json:"name"`
+				} `json:"metadata"`
+				Code int `json:"code"`
+			} `json:"object"`
+		}
+		if err := json.Unmarshal(events.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "ERROR" {
+			return e.Type, http.StatusText(e.Object.Code)
+		}
+		return e.Type, e.Object.Metadata.Name
+	}
+
+	k.Put(TLSSecret("web", "b", ca.Issue(t)))
+	b := TLSSecret("web", "b", ca.Issue(t))
+	b.Labels = map[string]string{"tier": "edge"}
+	k.Put(b)
+	if typ, name := next(); typ != "ADDED" || name != "b" {
+		t.Errorf("labelling b: %s %s, want ADDED b", typ, name)
+	}
+	k.Put(secret)
+	if typ, name := next(); typ != "MODIFIED" || name != "a" {
+		t.Errorf("updating a: %s %s, want MODIFIED a", typ, name)
+	}
+	secret.Labels = nil
+	k.Put(secret)
+	if typ, name := next(); typ != "DELETED" || name != "a" {
+		t.Errorf("unlabelling a: %s %s, want DELETED a", typ, name)
+	}
+	k.Delete("web", "b")
+	if typ, name := next(); typ != "DELETED" || name != "b" {
+		t.Errorf("deleting b: %s %s, want DELETED b", typ, name)
+	}
+
+	k.Compact()
+	if events.Scan() {
+		t.Errorf("watch continued after compaction: %s", events.Text())
+	}
+	resp2, err := http.Get(k.URL + "/api/v1/secrets?watch=true&resourceVersion=" + list.Metadata.ResourceVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	events = bufio.NewScanner(resp2.Body)
+	if typ, reason := next(); typ != "ERROR" || reason != http.StatusText(http.StatusGone) {
+		t.Errorf("watching a compacted version: %s %s, want ERROR Gone", typ, reason)
+	}
+}
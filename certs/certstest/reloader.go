@@ -0,0 +1,159 @@
+package certstest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+const (
+	DefaultWaitTimeout    = 5 * time.Second
+	DefaultReloadInterval = time.Minute
+)
+
+// NewReloader creates a reloader that watches the directory, with events
+// recorded by the returned recorder. The options are applied after the
+// defaults so they can override them.
+func NewReloader(t testing.TB, dir *Dir, opts ...certs.ReloaderOption) (*certs.Reloader, *Recorder) {
+	t.Helper()
+	rec := &Recorder{}
+	opts = append([]certs.ReloaderOption{
+		certs.OptReloaderDirs(dir.Path),
+		certs.OptReloaderWatch(true),
+		certs.OptReloaderEventHandler(rec.Handle),
+	}, opts...)
+	r, err := certs.NewReloader(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("certstest: creating reloader: %v", err)
+	}
+	return r, rec
+}
+
+// NewClockedReloader creates a reloader like NewReloader that does not watch
+// the directory, reloading on the fake clock's interval instead, so tests
+// decide when changes are picked up by calling Reload.
+func NewClockedReloader(t testing.TB, dir *Dir, clock *FakeClock, opts ...certs.ReloaderOption) (*certs.Reloader, *Recorder) {
+	t.Helper()
+	opts = append([]certs.ReloaderOption{
+		certs.OptReloaderWatch(false),
+		certs.OptReloaderClock(clock),
+		certs.OptReloaderInterval(DefaultReloadInterval),
+	}, opts...)
+	return NewReloader(t, dir, opts...)
+}
+
+// Reload advances the clock of a started clocked reloader by the reload
+// interval and waits for the reloader to take the tick. Use the recorder to
+// wait for the changes it loads.
+func Reload(t testing.TB, clock *FakeClock) {
+	t.Helper()
+	Eventually(t, func() bool {
+		running, _ := clock.Tickers()
+		return running > 0
+	})
+	clock.Advance(DefaultReloadInterval)
+	Eventually(t, func() bool {
+		_, pending := clock.Tickers()
+		return pending == 0
+	})
+}
+
+// Start runs the reloader until the test finishes.
+func Start(t testing.TB, r *certs.Reloader) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := r.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("certstest: reloader exited: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// Serving returns the leaf the reloader serves for the server name, or nil.
+func Serving(r *certs.Reloader, serverName string) *x509.Certificate {
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		return nil
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+// WaitServing waits until the reloader serves the pair for the server name.
+func WaitServing(t testing.TB, r *certs.Reloader, serverName string, pair *Pair) {
+	t.Helper()
+	Eventually(t, func() bool {
+		leaf := Serving(r, serverName)
+		return leaf != nil && leaf.Equal(pair.Cert)
+	})
+}
+
+// Eventually polls the condition until it holds or DefaultWaitTimeout
+// passes.
+func Eventually(t testing.TB, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("certstest: condition not met after %v", DefaultWaitTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Recorder collects reloader events.
+type Recorder struct {
+	lock   sync.Mutex
+	events []certs.Event
+	waited int
+}
+
+func (r *Recorder) Handle(e certs.Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *Recorder) Events() []certs.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]certs.Event(nil), r.events...)
+}
+
+// Wait waits for an event of the type for the cert name, ignoring events
+// before the last one returned by Wait.
+func (r *Recorder) Wait(t testing.TB, eventType certs.EventType, name string) certs.Event {
+	t.Helper()
+	var found certs.Event
+	Eventually(t, func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		for i := r.waited; i < len(r.events); i++ {
+			if e := r.events[i]; e.Type == eventType && e.Name == name {
+				found = e
+				r.waited = i + 1
+				return true
+			}
+		}
+		return false
+	})
+	return found
+}
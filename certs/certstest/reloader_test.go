@@ -0,0 +1,64 @@
+package certstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+func TestReloaderFollowsDir(t *testing.T) {
+	ca := NewCA(t)
+	dir := NewDir(t)
+	first := ca.Issue(t, OptDNSNames("web.test"))
+	dir.WritePair("web", first)
+
+	r, rec := NewReloader(t, dir)
+	Start(t, r)
+	if e := rec.Wait(t, certs.EventAdd, dir.Name("web")); e.Trigger != certs.TriggerInitial {
+		t.Errorf("add trigger = %s, want %s", e.Trigger, certs.TriggerInitial)
+	}
+	WaitServing(t, r, "web.test", first)
+
+	second := ca.Issue(t, OptDNSNames("web.test"))
+	dir.Rotate("web", second)
+	e := rec.Wait(t, certs.EventReplace, dir.Name("web"))
+	if !e.Previous.Leaf.Equal(first.Cert) || !e.Cert.Leaf.Equal(second.Cert) {
+		t.Errorf("replace event from %v to %v", e.Previous.Leaf.SerialNumber, e.Cert.Leaf.SerialNumber)
+	}
+	WaitServing(t, r, "web.test", second)
+
+	third := ca.Issue(t, OptDNSNames("web.test"))
+	finish := dir.TornRotate("web", third)
+	finish()
+	WaitServing(t, r, "web.test", third)
+
+	dir.Remove("web")
+	rec.Wait(t, certs.EventEvict, dir.Name("web"))
+	if leaf := Serving(r, "web.test"); leaf != nil {
+		t.Errorf("serving %v after removal", leaf.SerialNumber)
+	}
+}
+
+func TestReloaderOnFakeClock(t *testing.T) {
+	ca := NewCA(t)
+	dir := NewDir(t)
+	first := ca.Issue(t, OptDNSNames("web.test"))
+	dir.WritePair("web", first)
+
+	clock := NewFakeClock(time.Now())
+	r, rec := NewClockedReloader(t, dir, clock)
+	Start(t, r)
+	WaitServing(t, r, "web.test", first)
+
+	second := ca.Issue(t, OptDNSNames("web.test"))
+	dir.Rotate("web", second)
+	if leaf := Serving(r, "web.test"); !leaf.Equal(first.Cert) {
+		t.Fatalf("serving %v before the reload", leaf.SerialNumber)
+	}
+	Reload(t, clock)
+	if e := rec.Wait(t, certs.EventReplace, dir.Name("web")); e.Trigger != certs.TriggerInterval {
+		t.Errorf("replace trigger = %s, want %s", e.Trigger, certs.TriggerInterval)
+	}
+	WaitServing(t, r, "web.test", second)
+}
@@ -0,0 +1,292 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/blend/go-sdk/configutil"
+	"github.com/blend/go-sdk/logger"
+)
+
+const (
+	DefaultReloadInterval = 5 * time.Minute
+)
+
+// Config configures a Reloader from YAML or JSON, with CERTS_* environment
+// variables taking precedence over file values.
+type Config struct {
+	// Dirs are the directories loaded for cert pairs.
+	Dirs []string `yaml:"dirs" json:"dirs"`
+	// ReloadInterval defaults to DefaultReloadInterval.
+	ReloadInterval time.Duration `yaml:"reloadInterval" json:"reloadInterval"`
+	// Watch reloads on file system events, defaulting to true.
+	Watch *bool `yaml:"watch" json:"watch"`
+	// PairMode is the naming layout, "filename" or "publickey".
+	PairMode string `yaml:"pairMode" json:"pairMode"`
+	// DefaultCert is the pair served when no cert matches the server name, as
+	// a pair name or a cert file in one of the dirs.
+	DefaultCert string `yaml:"defaultCert" json:"defaultCert"`
+	// PolicyFile holds per-host TLS policies.
+	PolicyFile string `yaml:"policyFile" json:"policyFile"`
+	// Validation is the policy certs must satisfy to be loaded.
+	Validation *Policy `yaml:"validation" json:"validation"`
+	// TrustFiles are the CA bundles used to verify peers.
+	TrustFiles []string `yaml:"trustFiles" json:"trustFiles"`
+	// SPIFFEBundles maps trust domains to bundle files.
+	SPIFFEBundles  map[string]string `yaml:"spiffeBundles" json:"spiffeBundles"`
+	CRLFiles       []string          `yaml:"crlFiles" json:"crlFiles"`
+	PinFile        string            `yaml:"pinFile" json:"pinFile"`
+	PassphraseFile string            `yaml:"passphraseFile" json:"passphraseFile"`
+	// WatchConfig reloads the config file on change. Only DefaultCert is
+	// applied without restarting the reloader.
+	WatchConfig bool `yaml:"watchConfig" json:"watchConfig"`
+}
+
+// Resolve applies environment variable overrides.
+func (c *Config) Resolve(ctx context.Context) error {
+	return configutil.Resolve(ctx,
+		configutil.SetStrings(&c.Dirs, configutil.Env("CERTS_DIRS"), configutil.Strings(c.Dirs)),
+		configutil.SetDuration(&c.ReloadInterval, configutil.Env("CERTS_RELOAD_INTERVAL"), configutil.Duration(c.ReloadInterval)),
+		configutil.SetBoolPtr(&c.Watch, configutil.Env("CERTS_WATCH"), configutil.Bool(c.Watch)),
+		configutil.SetString(&c.PairMode, configutil.Env("CERTS_PAIR_MODE"), configutil.String(c.PairMode)),
+		configutil.SetString(&c.DefaultCert, configutil.Env("CERTS_DEFAULT_CERT"), configutil.String(c.DefaultCert)),
+		configutil.SetString(&c.PolicyFile, configutil.Env("CERTS_POLICY_FILE"), configutil.String(c.PolicyFile)),
+		configutil.SetStrings(&c.TrustFiles, configutil.Env("CERTS_TRUST_FILES"), configutil.Strings(c.TrustFiles)),
+		c.resolveSPIFFEBundles,
+		configutil.SetStrings(&c.CRLFiles, configutil.Env("CERTS_CRL_FILES"), configutil.Strings(c.CRLFiles)),
+		configutil.SetString(&c.PinFile, configutil.Env("CERTS_PIN_FILE"), configutil.String(c.PinFile)),
+		configutil.SetString(&c.PassphraseFile, configutil.Env("CERTS_PASSPHRASE_FILE"), configutil.String(c.PassphraseFile)),
+		configutil.SetBool(&c.WatchConfig, configutil.Env("CERTS_WATCH_CONFIG"), configutil.Bool(&c.WatchConfig)),
+	)
+}
+
+// resolveSPIFFEBundles replaces the bundles with CERTS_SPIFFE_BUNDLES when it
+// is set, as comma separated trust-domain=file entries.
+func (c *Config) resolveSPIFFEBundles(ctx context.Context) error {
+	entries, err := configutil.Env("CERTS_SPIFFE_BUNDLES").Strings(ctx)
+	if err != nil || entries == nil {
+		return err
+	}
+	bundles := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		td, file, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("CERTS_SPIFFE_BUNDLES: %q is not trust-domain=file", entry)
+		}
+		bundles[strings.TrimSpace(td)] = strings.TrimSpace(file)
+	}
+	c.SPIFFEBundles = bundles
+	return nil
+}
+
+func (c Config) ReloadIntervalOrDefault() time.Duration {
+	if c.ReloadInterval > 0 {
+		return c.ReloadInterval
+	}
+	return DefaultReloadInterval
+}
+
+func (c Config) WatchOrDefault() bool {
+	if c.Watch != nil {
+		return *c.Watch
+	}
+	return true
+}
+
+func (c Config) PairModeOrDefault() PairMode {
+	if len(c.PairMode) > 0 {
+		return PairMode(c.PairMode)
+	}
+	return PairModeFileName
+}
+
+// Validate returns every problem with the config, each prefixed with the
+// field it concerns.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("certs config: %s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	if len(c.Dirs) == 0 {
+		invalid("dirs", "at least one directory is required")
+	}
+	for _, dir := range c.Dirs {
+		if info, err := os.Stat(dir); err != nil {
+			invalid("dirs", "%v", err)
+		} else if !info.IsDir() {
+			invalid("dirs", "%s is not a directory", dir)
+		}
+	}
+	if c.ReloadInterval < 0 {
+		invalid("reloadInterval", "must not be negative, got %v", c.ReloadInterval)
+	}
+	switch c.PairModeOrDefault() {
+	case PairModeFileName, PairModePublicKey:
+	default:
+		invalid("pairMode", "must be %q or %q, got %q", PairModeFileName, PairModePublicKey, c.PairMode)
+	}
+	if len(c.DefaultCert) > 0 && len(c.dirFor(c.DefaultCert)) == 0 {
+		invalid("defaultCert", "%s is not in any of the dirs", c.DefaultCert)
+	}
+	checkFile := func(field, path string) {
+		if _, err := os.Stat(path); err != nil {
+			invalid(field, "%v", err)
+		}
+	}
+	if len(c.PolicyFile) > 0 {
+		checkFile("policyFile", c.PolicyFile)
+	}
+	for _, file := range c.TrustFiles {
+		checkFile("trustFiles", file)
+	}
+	for td, file := range c.SPIFFEBundles {
+		if _, err := ParseSPIFFEID("spiffe://" + td); err != nil {
+			invalid("spiffeBundles", "invalid trust domain %q", td)
+		}
+		checkFile("spiffeBundles", file)
+	}
+	for _, file := range c.CRLFiles {
+		checkFile("crlFiles", file)
+	}
+	if len(c.PinFile) > 0 {
+		checkFile("pinFile", c.PinFile)
+	}
+	if len(c.PassphraseFile) > 0 {
+		checkFile("passphraseFile", c.PassphraseFile)
+	}
+	if err := c.Validation.Validate(); err != nil {
+		invalid("validation", "%v", err)
+	}
+	return errors.Join(errs...)
+}
+
+func (c Config) dirFor(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	for _, dir := range c.Dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(absDir, abs); err == nil && filepath.IsLocal(rel) {
+			return absDir
+		}
+	}
+	return ""
+}
+
+// Options returns the reloader options for the config.
+func (c Config) Options() []ReloaderOption {
+	opts := []ReloaderOption{
+		OptReloaderDirs(c.Dirs...),
+		OptReloaderInterval(c.ReloadIntervalOrDefault()),
+		OptReloaderWatch(c.WatchOrDefault()),
+		OptReloaderPairMode(c.PairModeOrDefault()),
+	}
+	if len(c.DefaultCert) > 0 {
+		opts = append(opts, OptReloaderDefaultCert(c.DefaultCert))
+	}
+	if len(c.PolicyFile) > 0 {
+		opts = append(opts, OptReloaderPolicyFile(c.PolicyFile))
+	}
+	if c.Validation != nil {
+		opts = append(opts, OptReloaderPolicy(c.Validation))
+	}
+	if len(c.TrustFiles) > 0 {
+		opts = append(opts, OptReloaderTrustFiles(c.TrustFiles...))
+	}
+	for td, file := range c.SPIFFEBundles {
+		opts = append(opts, OptReloaderSPIFFEBundle(td, file))
+	}
+	if len(c.CRLFiles) > 0 {
+		opts = append(opts, OptReloaderCRLFiles(c.CRLFiles...))
+	}
+	if len(c.PinFile) > 0 {
+		opts = append(opts, OptReloaderPinFile(c.PinFile))
+	}
+	if len(c.PassphraseFile) > 0 {
+		opts = append(opts, OptReloaderPassphrase(PassphraseFile(c.PassphraseFile)))
+	}
+	return opts
+}
+
+// ReadConfig reads, resolves and validates the config file.
+func ReadConfig(path string) (Config, error) {
+	var cfg Config
+	paths, err := configutil.Read(&cfg, configutil.OptPaths(path))
+	if err != nil {
+		return Config{}, fmt.Errorf("reading certs config %s: %w", path, err)
+	}
+	if len(paths) == 0 {
+		return Config{}, fmt.Errorf("reading certs config %s: %w", path, os.ErrNotExist)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// NewReloaderFromConfig validates the config and creates a reloader from it.
+// Options are applied after those from the config.
+func NewReloaderFromConfig(ctx context.Context, cfg Config, opts ...ReloaderOption) (*Reloader, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return NewReloader(ctx, append(cfg.Options(), opts...)...)
+}
+
+// NewReloaderFromConfigFile reads the config file and creates a reloader
+// from it, watching the file when the config sets WatchConfig.
+func NewReloaderFromConfigFile(ctx context.Context, path string, opts ...ReloaderOption) (*Reloader, error) {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WatchConfig {
+		opts = append([]ReloaderOption{OptReloaderConfigFile(path)}, opts...)
+	}
+	return NewReloaderFromConfig(ctx, cfg, opts...)
+}
+
+// loadConfig rereads the watched config file, applying a changed default cert
+// and warning about changes that need a restart. The first load only records
+// the config, which the reloader was created from along with options that
+// may override it.
+func (r *Reloader) loadConfig(ctx context.Context) error {
+	cfg, err := ReadConfig(r.ConfigFile)
+	if err != nil {
+		return err
+	}
+	prev := r.config.Swap(&cfg)
+	if prev == nil {
+		return nil
+	}
+	for _, field := range configChanges(*prev, cfg) {
+		if field == "DefaultCert" {
+			logger.MaybeInfofContext(ctx, r.Log, "Default cert changed to %s", cfg.DefaultCert)
+			r.setDefaultCert(cfg.DefaultCert)
+			continue
+		}
+		logger.MaybeWarningfContext(ctx, r.Log, "Certs config %s changed in %s, restart the reloader to apply it", field, r.ConfigFile)
+	}
+	return nil
+}
+
+func configChanges(a, b Config) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,163 @@
+package certs_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blend/go-sdk/env"
+	"github.com/mat285/go-sdk/certs"
+	"github.com/mat285/go-sdk/certs/certstest"
+)
+
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	for _, tc := range []struct {
+		name   string
+		cfg    certs.Config
+		fields []string
+	}{
+		{name: "valid", cfg: certs.Config{
+			Dirs:          []string{dir},
+			PairMode:      "publickey",
+			DefaultCert:   filepath.Join(dir, "web"),
+			TrustFiles:    []string{file},
+			SPIFFEBundles: map[string]string{"example.org": file},
+			PinFile:       file,
+		}},
+		{name: "no dirs", fields: []string{"dirs"}},
+		{name: "bad dirs", cfg: certs.Config{Dirs: []string{missing, file}}, fields: []string{"dirs", "dirs"}},
+		{name: "every field", cfg: certs.Config{
+			Dirs:           []string{dir},
+			ReloadInterval: -time.Second,
+			PairMode:       "bundle",
+			DefaultCert:    "/elsewhere/web",
+			PolicyFile:     missing,
+			TrustFiles:     []string{missing},
+			SPIFFEBundles:  map[string]string{"not a domain": file},
+			CRLFiles:       []string{missing},
+			PinFile:        missing,
+			PassphraseFile: missing,
+		}, fields: []string{"reloadInterval", "pairMode", "defaultCert", "policyFile", "trustFiles", "spiffeBundles", "crlFiles", "pinFile", "passphraseFile"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			var fields []string
+			if err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					field, _, _ := strings.Cut(strings.TrimPrefix(line, "certs config: "), ":")
+					fields = append(fields, field)
+				}
+			}
+			if !reflect.DeepEqual(fields, tc.fields) {
+				t.Errorf("invalid fields = %v, want %v: %v", fields, tc.fields, err)
+			}
+		})
+	}
+}
+
+func TestConfigResolveEnv(t *testing.T) {
+	cfg := certs.Config{
+		Dirs:          []string{"/file"},
+		PinFile:       "/file/pins",
+		SPIFFEBundles: map[string]string{"file.org": "/file/bundle"},
+	}
+	ctx := env.WithVars(context.Background(), env.Vars{
+		"CERTS_DIRS":           "/a,/b",
+		"CERTS_PIN_FILE":       "/env/pins",
+		"CERTS_SPIFFE_BUNDLES": "example.org=/env/example, other.org=/env/other",
+		"CERTS_WATCH_CONFIG":   "true",
+	})
+	if err := cfg.Resolve(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := certs.Config{
+		Dirs:          []string{"/a", "/b"},
+		PinFile:       "/env/pins",
+		SPIFFEBundles: map[string]string{"example.org": "/env/example", "other.org": "/env/other"},
+		WatchConfig:   true,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("resolved %+v, want %+v", cfg, want)
+	}
+
+	bad := env.WithVars(context.Background(), env.Vars{"CERTS_SPIFFE_BUNDLES": "example.org"})
+	if err := (&certs.Config{}).Resolve(bad); err == nil {
+		t.Error("resolved a SPIFFE bundle without a file")
+	}
+}
+
+func writeConfig(t *testing.T, path, data string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := certstest.NewDir(t)
+	path := filepath.Join(t.TempDir(), "certs.yml")
+	writeConfig(t, path, "dirs: ["+dir.Path+"]\nreloadInterval: 1m\nwatch: false\n")
+	cfg, err := certs.ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReloadIntervalOrDefault() != time.Minute || cfg.WatchOrDefault() || cfg.PairModeOrDefault() != certs.PairModeFileName {
+		t.Errorf("read %+v", cfg)
+	}
+
+	writeConfig(t, path, "dirs: ["+dir.Path+"]\npairMode: bundle\n")
+	if _, err := certs.ReadConfig(path); err == nil || !strings.Contains(err.Error(), "pairMode") {
+		t.Errorf("reading an invalid config: %v", err)
+	}
+	if _, err := certs.ReadConfig(filepath.Join(t.TempDir(), "missing.yml")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reading a missing config: %v", err)
+	}
+}
+
+func TestConfigFileDefaultCert(t *testing.T) {
+	ca := certstest.NewCA(t)
+	dir := certstest.NewDir(t)
+	web := ca.Issue(t, certstest.OptDNSNames("web.test"))
+	api := ca.Issue(t, certstest.OptDNSNames("api.test"))
+	www := ca.Issue(t, certstest.OptDNSNames("www.test"))
+	dir.WritePair("web", web)
+	dir.WritePair("api", api)
+	dir.WritePair("www", www)
+	path := filepath.Join(t.TempDir(), "certs.yml")
+	writeConfig(t, path, "dirs: ["+dir.Path+"]\ndefaultCert: "+dir.Name("web")+"\nwatchConfig: true\n")
+
+	r, err := certs.NewReloaderFromConfigFile(context.Background(), path, certs.OptReloaderDefaultCert(dir.Name("api")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := certstest.Serving(r, "other.test"); leaf == nil || !leaf.Equal(api.Cert) {
+		t.Fatal("the config file overrode the default cert option")
+	}
+
+	certstest.Start(t, r)
+	writeConfig(t, path, "dirs: ["+dir.Path+"]\ndefaultCert: "+dir.Name("www")+"\nwatchConfig: true\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	certstest.Eventually(t, func() bool {
+		leaf := certstest.Serving(r, "other.test")
+		return leaf != nil && leaf.Equal(www.Cert)
+	})
+}
@@ -0,0 +1,189 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+var (
+	ErrCertificateRevoked = errors.New("certificate has been revoked")
+	ErrCRLStale           = errors.New("certificate revocation list is past its next update")
+	ErrCRLIssuerMissing   = errors.New("peer chain is missing the issuer needed to check the certificate revocation list")
+)
+
+type CRLPolicy string
+
+const (
+	// CRLFailClosed rejects handshakes whose issuer CRL is stale or cannot
+	// be checked because the issuer is missing from the chain.
+	CRLFailClosed CRLPolicy = "fail-closed"
+	// CRLFailOpen accepts such handshakes with a warning.
+	CRLFailOpen CRLPolicy = "fail-open"
+)
+
+type CRLStats struct {
+	Checked uint64
+	Revoked uint64
+	Stale   uint64
+	// Unchecked counts chains missing the issuer of a certificate with a CRL.
+	Unchecked uint64
+}
+
+type crlStats struct {
+	checked   atomic.Uint64
+	revoked   atomic.Uint64
+	stale     atomic.Uint64
+	unchecked atomic.Uint64
+}
+
+// CRL is a parsed revocation list indexed by revoked serial number.
+type CRL struct {
+	List    *x509.RevocationList
+	revoked map[string]bool
+}
+
+func (c *CRL) IsRevoked(cert *x509.Certificate) bool {
+	return c.revoked[cert.SerialNumber.String()]
+}
+
+// LoadCRLs reads PEM or DER revocation lists, keeping the newest list per
+// issuer, keyed by the issuer's raw subject.
+func LoadCRLs(files ...string) (map[string]*CRL, error) {
+	crls := make(map[string]*CRL)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		ders := [][]byte{data}
+		if isPEM(data) {
+			ders = ders[:0]
+			for {
+				var block *pem.Block
+				block, data = pem.Decode(data)
+				if block == nil {
+					break
+				}
+				if block.Type == "X509 CRL" {
+					ders = append(ders, block.Bytes)
+				}
+			}
+		}
+		for _, der := range ders {
+			list, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			issuer := string(list.RawIssuer)
+			if prev := crls[issuer]; prev != nil && !list.ThisUpdate.After(prev.List.ThisUpdate) {
+				continue
+			}
+			entry := &CRL{List: list, revoked: make(map[string]bool, len(list.RevokedCertificateEntries))}
+			for _, rc := range list.RevokedCertificateEntries {
+				entry.revoked[rc.SerialNumber.String()] = true
+			}
+			crls[issuer] = entry
+		}
+	}
+	return crls, nil
+}
+
+func (r *Reloader) loadCRLs(ctx context.Context) error {
+	crls, err := LoadCRLs(r.CRLFiles...)
+	if err != nil {
+		return err
+	}
+	now := r.now()
+	for _, entry := range crls {
+		if !entry.List.NextUpdate.IsZero() && now.After(entry.List.NextUpdate) {
+			logger.MaybeWarningfContext(ctx, r.Log, "CRL for %s is past its next update %s", entry.List.Issuer, entry.List.NextUpdate.Format(time.RFC3339))
+		}
+	}
+	logger.MaybeDebugfContext(ctx, r.Log, "Loaded %d CRLs", len(crls))
+	r.crls.Store(&crls)
+	return nil
+}
+
+func (r *Reloader) CRLStats() CRLStats {
+	return CRLStats{
+		Checked:   r.crlStats.checked.Load(),
+		Revoked:   r.crlStats.revoked.Load(),
+		Stale:     r.crlStats.stale.Load(),
+		Unchecked: r.crlStats.unchecked.Load(),
+	}
+}
+
+// VerifyCRL is a VerifyConnection func that rejects peer chains containing a
+// certificate revoked by a loaded CRL. It runs on resumed sessions too, so a
+// revoked client cannot keep resuming. Issuers without a CRL are not checked.
+// Only chains verified by crypto/tls are checked, so it does nothing for
+// client auth modes that do not verify, such as RequireAnyClientCert. The
+// SPIFFE configs check the chains they verify themselves.
+func (r *Reloader) VerifyCRL(cs tls.ConnectionState) error {
+	return r.checkCRLs(cs.VerifiedChains)
+}
+
+func (r *Reloader) checkCRLs(chains [][]*x509.Certificate) error {
+	if len(chains) == 0 {
+		return nil
+	}
+	r.crlStats.checked.Add(1)
+	for _, chain := range chains {
+		if err := r.checkChainCRL(chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reloader) checkChainCRL(chain []*x509.Certificate) error {
+	crlsp := r.crls.Load()
+	if crlsp == nil {
+		return nil
+	}
+	crls := *crlsp
+	now := r.now()
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		entry := crls[string(cert.RawIssuer)]
+		if entry == nil {
+			continue
+		}
+		if err := entry.List.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("invalid CRL for %s: %w", cert.Issuer, err)
+		}
+		if !entry.List.NextUpdate.IsZero() && now.After(entry.List.NextUpdate) {
+			r.crlStats.stale.Add(1)
+			if r.CRLPolicy != CRLFailOpen {
+				return fmt.Errorf("%w for %s", ErrCRLStale, cert.Issuer)
+			}
+			logger.MaybeWarningf(r.Log, "Accepting %s with stale CRL for %s", cert.Subject, cert.Issuer)
+		}
+		if entry.IsRevoked(cert) {
+			r.crlStats.revoked.Add(1)
+			logger.MaybeWarningf(r.Log, "Rejected revoked certificate %s serial %s", cert.Subject, cert.SerialNumber)
+			return fmt.Errorf("%w: %s serial %s", ErrCertificateRevoked, cert.Subject, cert.SerialNumber)
+		}
+	}
+	// a chain that stops short of its issuer, such as a lone leaf, cannot
+	// have its last certificate checked
+	last := chain[len(chain)-1]
+	if crls[string(last.RawIssuer)] != nil && !bytes.Equal(last.RawIssuer, last.RawSubject) {
+		r.crlStats.unchecked.Add(1)
+		if r.CRLPolicy != CRLFailOpen {
+			return fmt.Errorf("%w for %s", ErrCRLIssuerMissing, last.Subject)
+		}
+		logger.MaybeWarningf(r.Log, "Accepting %s without its issuer %s to check the CRL", last.Subject, last.Issuer)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventAdd     EventType = "add"
+	EventReplace EventType = "replace"
+	EventEvict   EventType = "evict"
+
+	// EventUnknownSNI is emitted the first time a server name with no
+	// matching cert is tracked. Cert is nil. It is delivered in the
+	// background and dropped when handlers fall behind.
+	EventUnknownSNI EventType = "unknown_sni"
+	// EventPinFailure is emitted when a server matches none of its pins. Err
+	// is the PinError.
+	EventPinFailure EventType = "pin_failure"
+)
+
+// Trigger is what caused a cache change.
+type Trigger string
+
+const (
+	TriggerInitial   Trigger = "initial"
+	TriggerFSEvent   Trigger = "fs"
+	TriggerInterval  Trigger = "interval"
+	TriggerManual    Trigger = "manual"
+	TriggerStaged    Trigger = "staged"
+	TriggerRenewal   Trigger = "renewal"
+	TriggerHandshake Trigger = "handshake"
+	TriggerPin       Trigger = "pin"
+	TriggerUnpin     Trigger = "unpin"
+	TriggerRollback  Trigger = "rollback"
+	TriggerSource    Trigger = "source"
+)
+
+// Event describes a change to the certs served by a cache, or a handshake
+// the reloader could not serve. Previous is set when a cert is replaced.
+type Event struct {
+	Type     EventType
+	Trigger  Trigger
+	Name     string
+	Cert     *Cert
+	Previous *Cert
+	Time     time.Time
+	Err      error
+}
+
+// EventHandler is called after a cache change. Handlers are called outside the
+// cache lock but should return quickly.
+type EventHandler func(Event)
+
+// Fingerprint returns the hex encoded SHA-256 of the leaf certificate.
+func (c *Cert) Fingerprint() string {
+	if c == nil || len(c.Certificate.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(c.Certificate.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) OnEvent(handler EventHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+func (c *Cache) record(t EventType, trigger Trigger, cert, previous *Cert) {
+	c.pending = append(c.pending, Event{
+		Type:     t,
+		Trigger:  trigger,
+		Name:     cert.Name,
+		Cert:     cert,
+		Previous: previous,
+		Time:     c.now(),
+	})
+}
+
+// unlock releases the cache lock and delivers the events recorded while it
+// was held.
+func (c *Cache) unlock() {
+	events := c.pending
+	c.pending = nil
+	handlers := c.handlers
+	c.lock.Unlock()
+	for _, e := range events {
+		for _, h := range handlers {
+			h(e)
+		}
+	}
+}
@@ -9,9 +9,12 @@ import (
 type FileType string
 
 const (
-	FileTypeUnknown FileType = ""
-	FileTypeCert    FileType = "crt"
-	FileTypeKey     FileType = "key"
+	FileTypeUnknown  FileType = ""
+	FileTypeCert     FileType = "crt"
+	FileTypeKey      FileType = "key"
+	FileTypePKCS12   FileType = "p12"
+	FileTypeMetadata FileType = "metadata"
+	FileTypeSCT      FileType = "sct"
 )
 
 type File struct {
@@ -0,0 +1,147 @@
+package certs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+const (
+	DefaultHistoryLimit = 5
+)
+
+var (
+	ErrUnknownCert       = errors.New("unknown cert")
+	ErrNoPreviousVersion = errors.New("no previous version")
+)
+
+// CertVersion is a cert the cache has served, or would have served had the
+// name not been pinned. Versions are numbered per name from 1.
+type CertVersion struct {
+	Version   int
+	Cert      *Cert
+	Installed time.Time
+}
+
+// History returns the retained versions of the name, oldest first.
+func (c *Cache) History(name string) []CertVersion {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]CertVersion(nil), c.history[name]...)
+}
+
+// Pinned returns the version the name is pinned to, if any.
+func (c *Cache) Pinned(name string) (int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	version, has := c.pinned[name]
+	return version, has
+}
+
+// Pin serves the version of the name until unpinned, ignoring newer certs
+// loaded in the meantime.
+func (c *Cache) Pin(name string, version int) error {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.pin(name, version, TriggerPin)
+}
+
+// Rollback pins the name to the version before the one being served and
+// returns it.
+func (c *Cache) Rollback(name string) (int, error) {
+	c.lock.Lock()
+	defer c.unlock()
+	versions := c.history[name]
+	current := c.servedVersion(name)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Version < current {
+			return versions[i].Version, c.pin(name, versions[i].Version, TriggerRollback)
+		}
+	}
+	return 0, fmt.Errorf("%w for %s", ErrNoPreviousVersion, name)
+}
+
+// Unpin resumes serving the latest version of the name.
+func (c *Cache) Unpin(name string) error {
+	c.lock.Lock()
+	defer c.unlock()
+	if _, has := c.pinned[name]; !has {
+		return nil
+	}
+	delete(c.pinned, name)
+	versions := c.history[name]
+	if len(versions) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownCert, name)
+	}
+	logger.MaybeInfof(c.log, "Unpinned cert %s", name)
+	c.serve(versions[len(versions)-1].Cert, TriggerUnpin)
+	c.reindex()
+	return nil
+}
+
+func (c *Cache) pin(name string, version int, trigger Trigger) error {
+	v := c.version(name, version)
+	if v == nil {
+		return fmt.Errorf("%w: %s version %d", ErrUnknownCert, name, version)
+	}
+	logger.MaybeInfof(c.log, "Pinning cert %s to version %d", name, version)
+	c.pinned[name] = version
+	c.serve(v.Cert, trigger)
+	c.reindex()
+	return nil
+}
+
+func (c *Cache) version(name string, version int) *CertVersion {
+	versions := c.history[name]
+	for i := range versions {
+		if versions[i].Version == version {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// servedVersion returns the version of the cert being served for the name,
+// or 0 if it is no longer retained.
+func (c *Cache) servedVersion(name string) int {
+	fingerprint := c.certs[name].Fingerprint()
+	if len(fingerprint) == 0 {
+		return 0
+	}
+	versions := c.history[name]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Cert.Fingerprint() == fingerprint {
+			return versions[i].Version
+		}
+	}
+	return 0
+}
+
+// remember adds the cert to the history of its name unless it matches the
+// latest version, returning its version.
+func (c *Cache) remember(cert *Cert) int {
+	versions := c.history[cert.Name]
+	if n := len(versions); n > 0 && versions[n-1].Cert.Fingerprint() == cert.Fingerprint() {
+		versions[n-1].Cert = cert
+		return versions[n-1].Version
+	}
+	next := CertVersion{Version: 1, Cert: cert, Installed: c.now()}
+	if n := len(versions); n > 0 {
+		next.Version = versions[n-1].Version + 1
+	}
+	versions = append(versions, next)
+	if limit := c.historyLimit(); len(versions) > limit {
+		versions = append([]CertVersion(nil), versions[len(versions)-limit:]...)
+	}
+	c.history[cert.Name] = versions
+	return next.Version
+}
+
+func (c *Cache) historyLimit() int {
+	if c.HistoryLimit > 0 {
+		return c.HistoryLimit
+	}
+	return DefaultHistoryLimit
+}
@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Issuer signs a certificate request, returning the issued chain with the
+// leaf first.
+type Issuer interface {
+	Issue(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error)
+}
+
+type IssuerFunc func(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error)
+
+func (f IssuerFunc) Issue(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	return f(ctx, csr)
+}
+
+const (
+	DefaultLocalCAValidity = 90 * 24 * time.Hour
+)
+
+// LocalCA is an Issuer that signs requests with a CA certificate and key held
+// in process.
+type LocalCA struct {
+	Cert     *x509.Certificate
+	Key      crypto.Signer
+	Validity time.Duration
+	Clock    Clock
+}
+
+// NewLocalCA loads a CA pair from disk using the loader, so encrypted, DER and
+// PKCS#12 CA keys are supported.
+func NewLocalCA(loader *Loader, certFile, keyFile string) (*LocalCA, error) {
+	cert, err := loader.LoadCertFiles("ca", certFile, keyFile, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if !cert.Leaf.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a signing key", keyFile)
+	}
+	return &LocalCA{
+		Cert: cert.Leaf,
+		Key:  key,
+	}, nil
+}
+
+func (ca *LocalCA) Issue(_ context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	if ca == nil || ca.Cert == nil || ca.Key == nil {
+		return nil, errors.New("local ca is not configured")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	validity := ca.Validity
+	if validity <= 0 {
+		validity = DefaultLocalCAValidity
+	}
+	now := clockOrSystem(ca.Clock).Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+		EmailAddresses: csr.EmailAddresses,
+		NotBefore:      now.Add(-time.Minute),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if csr.PublicKeyAlgorithm == x509.RSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, csr.PublicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{leaf, ca.Cert}, nil
+}
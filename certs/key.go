@@ -0,0 +1,48 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+)
+
+type publicKey interface {
+	Equal(crypto.PublicKey) bool
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		switch key := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+			return key, nil
+		default:
+			return nil, errors.New("unknown private key type in PKCS#8 wrapping")
+		}
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
+
+func publicKeyOf(key crypto.PrivateKey) crypto.PublicKey {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil
+	}
+	return signer.Public()
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	pa, ok := a.(publicKey)
+	if !ok {
+		return false
+	}
+	return pa.Equal(b)
+}
@@ -0,0 +1,72 @@
+package kube
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+type Option func(*Source)
+
+func OptLogger(log certs.Logger) Option {
+	return func(s *Source) {
+		s.Log = log
+	}
+}
+
+// OptNamespace limits the source to a namespace. All namespaces are watched
+// by default.
+func OptNamespace(namespace string) Option {
+	return func(s *Source) {
+		s.Namespace = namespace
+	}
+}
+
+// OptLabelSelector limits the source to secrets matching the selector, such
+// as "app=web,tier!=canary".
+func OptLabelSelector(selector string) Option {
+	return func(s *Source) {
+		s.LabelSelector = selector
+	}
+}
+
+func OptHTTPClient(client *http.Client) Option {
+	return func(s *Source) {
+		s.Client = client
+	}
+}
+
+func OptToken(token string) Option {
+	return func(s *Source) {
+		s.Token = token
+	}
+}
+
+// OptTokenFile reads the bearer token from the file for each request, so
+// rotated service account tokens are picked up.
+func OptTokenFile(path string) Option {
+	return func(s *Source) {
+		s.TokenFile = path
+	}
+}
+
+func OptRetryInterval(interval time.Duration) Option {
+	return func(s *Source) {
+		s.RetryInterval = interval
+	}
+}
+
+func OptPageSize(size int) Option {
+	return func(s *Source) {
+		s.PageSize = size
+	}
+}
+
+// OptWatchTimeout sets how long each watch is held open, which must be at
+// least a second.
+func OptWatchTimeout(timeout time.Duration) Option {
+	return func(s *Source) {
+		s.WatchTimeout = timeout
+	}
+}
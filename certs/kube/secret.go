@@ -0,0 +1,87 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mat285/go-sdk/certs"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SecretTypeTLS = "kubernetes.io/tls"
+
+	// AnnotationMetadata holds cert metadata as YAML or JSON, in the format of
+	// a metadata sidecar file.
+	AnnotationMetadata = "certs.go-sdk/metadata"
+
+	certKey = "tls.crt"
+	keyKey  = "tls.key"
+)
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+type secret struct {
+	Metadata objectMeta `json:"metadata"`
+	Type     string     `json:"type"`
+	// Data values are base64 in JSON, which encoding/json decodes into bytes.
+	Data map[string][]byte `json:"data"`
+}
+
+type secretList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+		Continue        string `json:"continue"`
+	} `json:"metadata"`
+	Items []secret `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (s status) Error() string {
+	return fmt.Sprintf("kubernetes api: %d %s: %s", s.Code, s.Reason, s.Message)
+}
+
+// CertName returns the name of the cert for a secret.
+func CertName(namespace, name string) string {
+	return "kubernetes:" + namespace + "/" + name
+}
+
+func (s secret) certName() string {
+	return CertName(s.Metadata.Namespace, s.Metadata.Name)
+}
+
+func (s secret) cert(loader *certs.Loader) (*certs.Cert, error) {
+	name := s.certName()
+	certPEM, keyPEM := s.Data[certKey], s.Data[keyKey]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return nil, fmt.Errorf("%s: missing %s or %s", name, certKey, keyKey)
+	}
+	cert, err := loader.LoadCertPEM(name, certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if md := s.Metadata.Annotations[AnnotationMetadata]; len(md) > 0 {
+		var metadata certs.Metadata
+		if err := yaml.Unmarshal([]byte(md), &metadata); err != nil {
+			return nil, fmt.Errorf("%s: parsing %s annotation: %w", name, AnnotationMetadata, err)
+		}
+		cert.Metadata = &metadata
+	}
+	return cert, nil
+}
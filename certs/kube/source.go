@@ -0,0 +1,325 @@
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+	"github.com/mat285/go-sdk/certs"
+)
+
+const (
+	DefaultRetryInterval  = 5 * time.Second
+	DefaultPageSize       = 500
+	DefaultWatchTimeout   = 5 * time.Minute
+	DefaultRequestTimeout = 30 * time.Second
+
+	// watchTimeoutSlack is how long past the watch timeout the API server has
+	// to end the watch before the connection is assumed dead.
+	watchTimeoutSlack = 30 * time.Second
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+var (
+	ErrNotInCluster = errors.New("not running in a kubernetes cluster")
+)
+
+// Source lists and watches kubernetes.io/tls Secrets through the API server,
+// feeding them to a reloader without waiting for the kubelet to sync mounted
+// secrets. Sources must not select the same secrets.
+type Source struct {
+	Log certs.Logger
+	// Server is the API server URL.
+	Server        string
+	Namespace     string
+	LabelSelector string
+	Client        *http.Client
+	Token         string
+	TokenFile     string
+	RetryInterval time.Duration
+	// PageSize is the number of secrets listed per request.
+	PageSize int
+	// WatchTimeout is how long the API server holds each watch open before
+	// it is restarted from the last resource version.
+	WatchTimeout time.Duration
+
+	resourceVersion string
+}
+
+var _ certs.Source = (*Source)(nil)
+
+func New(server string, opts ...Option) *Source {
+	s := &Source{Server: server}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// InCluster returns a source that authenticates with the pod's service
+// account, watching the pod's namespace unless OptNamespace is given.
+func InCluster(opts ...Option) (*Source, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, ErrNotInCluster
+	}
+	pool, err := certs.LoadTrustPool(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+	base := []Option{
+		OptHTTPClient(client),
+		OptTokenFile(filepath.Join(serviceAccountDir, "token")),
+		OptNamespace(strings.TrimSpace(string(namespace))),
+	}
+	return New("https://"+net.JoinHostPort(host, port), append(base, opts...)...), nil
+}
+
+func (s *Source) Name() string {
+	name := "kubernetes"
+	if len(s.Namespace) > 0 {
+		name += "/" + s.Namespace
+	}
+	if len(s.LabelSelector) > 0 {
+		name += "[" + s.LabelSelector + "]"
+	}
+	return name
+}
+
+// Load lists the secrets, replacing the source's certs with them.
+func (s *Source) Load(ctx context.Context, sink *certs.SourceSink) error {
+	secrets, resourceVersion, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+	present := make([]string, 0, len(secrets))
+	loaded := make([]*certs.Cert, 0, len(secrets))
+	for _, secret := range secrets {
+		present = append(present, secret.certName())
+		cert, err := secret.cert(sink.Loader())
+		if err != nil {
+			logger.MaybeWarningfContext(ctx, s.Log, "Skipping secret %s/%s: %v", secret.Metadata.Namespace, secret.Metadata.Name, err)
+			continue
+		}
+		loaded = append(loaded, cert)
+	}
+	sink.Replace(present, loaded...)
+	s.resourceVersion = resourceVersion
+	logger.MaybeDebugfContext(ctx, s.Log, "Listed %d secrets from %s at resource version %s", len(secrets), s.Name(), resourceVersion)
+	return nil
+}
+
+// Watch applies secret changes until the context is cancelled, relisting
+// when the API server has compacted past the last seen resource version.
+func (s *Source) Watch(ctx context.Context, sink *certs.SourceSink) error {
+	for {
+		err := s.watch(ctx, sink)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var st status
+		if errors.As(err, &st) && st.Code == http.StatusGone {
+			logger.MaybeDebugfContext(ctx, s.Log, "Resource version %s expired, relisting %s", s.resourceVersion, s.Name())
+			err = s.Load(ctx, sink)
+		}
+		if err == nil {
+			continue
+		}
+		logger.MaybeErrorfContext(ctx, s.Log, "Error watching %s, retrying in %v: %v", s.Name(), s.retryInterval(), err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.retryInterval()):
+		}
+	}
+}
+
+func (s *Source) watch(ctx context.Context, sink *certs.SourceSink) error {
+	timeout := s.watchTimeout()
+	// the API server ends the watch after the timeout, the deadline ends it
+	// if the connection died without the server's end arriving
+	watchCtx, cancel := context.WithTimeout(ctx, timeout+watchTimeoutSlack)
+	defer cancel()
+	resp, err := s.get(watchCtx, url.Values{
+		"watch":               {"true"},
+		"resourceVersion":     {s.resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {strconv.Itoa(int(timeout / time.Second))},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := dec.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if ctx.Err() == nil && errors.Is(watchCtx.Err(), context.DeadlineExceeded) {
+				logger.MaybeWarningfContext(ctx, s.Log, "Watch of %s outlived its timeout, restarting it", s.Name())
+				return nil
+			}
+			return err
+		}
+		if event.Type == "ERROR" {
+			var st status
+			if err := json.Unmarshal(event.Object, &st); err != nil {
+				return err
+			}
+			return st
+		}
+		var secret secret
+		if err := json.Unmarshal(event.Object, &secret); err != nil {
+			return err
+		}
+		s.apply(ctx, sink, event.Type, secret)
+		if len(secret.Metadata.ResourceVersion) > 0 {
+			s.resourceVersion = secret.Metadata.ResourceVersion
+		}
+	}
+}
+
+func (s *Source) apply(ctx context.Context, sink *certs.SourceSink, eventType string, secret secret) {
+	switch eventType {
+	case "ADDED", "MODIFIED":
+		cert, err := secret.cert(sink.Loader())
+		if err != nil {
+			logger.MaybeWarningfContext(ctx, s.Log, "Skipping secret %s/%s, keeping any previous cert: %v", secret.Metadata.Namespace, secret.Metadata.Name, err)
+			return
+		}
+		sink.Set(cert)
+	case "DELETED":
+		sink.Evict(secret.certName())
+	}
+}
+
+func (s *Source) list(ctx context.Context) ([]secret, string, error) {
+	var secrets []secret
+	query := url.Values{"limit": {strconv.Itoa(s.pageSize())}}
+	for {
+		page, err := s.listPage(ctx, query)
+		if err != nil {
+			return nil, "", err
+		}
+		secrets = append(secrets, page.Items...)
+		if len(page.Metadata.Continue) == 0 {
+			return secrets, page.Metadata.ResourceVersion, nil
+		}
+		query.Set("continue", page.Metadata.Continue)
+	}
+}
+
+func (s *Source) listPage(ctx context.Context, query url.Values) (secretList, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultRequestTimeout)
+	defer cancel()
+	resp, err := s.get(ctx, query)
+	if err != nil {
+		return secretList{}, err
+	}
+	defer resp.Body.Close()
+	var page secretList
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return secretList{}, err
+	}
+	return page, nil
+}
+
+func (s *Source) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	path := "/api/v1/secrets"
+	if len(s.Namespace) > 0 {
+		path = "/api/v1/namespaces/" + url.PathEscape(s.Namespace) + "/secrets"
+	}
+	query.Set("fieldSelector", "type="+SecretTypeTLS)
+	if len(s.LabelSelector) > 0 {
+		query.Set("labelSelector", s.LabelSelector)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(s.Server, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token, err := s.token()
+	if err != nil {
+		return nil, err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var st status
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil || st.Code == 0 {
+			return nil, fmt.Errorf("kubernetes api: %s", resp.Status)
+		}
+		return nil, st
+	}
+	return resp, nil
+}
+
+func (s *Source) token() (string, error) {
+	if len(s.TokenFile) == 0 {
+		return s.Token, nil
+	}
+	data, err := os.ReadFile(s.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *Source) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *Source) retryInterval() time.Duration {
+	if s.RetryInterval > 0 {
+		return s.RetryInterval
+	}
+	return DefaultRetryInterval
+}
+
+func (s *Source) pageSize() int {
+	if s.PageSize > 0 {
+		return s.PageSize
+	}
+	return DefaultPageSize
+}
+
+func (s *Source) watchTimeout() time.Duration {
+	if s.WatchTimeout >= time.Second {
+		return s.WatchTimeout
+	}
+	return DefaultWatchTimeout
+}
@@ -0,0 +1,185 @@
+package kube
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+	"github.com/mat285/go-sdk/certs/certstest"
+)
+
+// edgeSecret returns a TLS secret in the web namespace selected by the test
+// sources.
+func edgeSecret(t *testing.T, ca *certstest.CA, name string) certstest.KubeSecret {
+	t.Helper()
+	secret := certstest.TLSSecret("web", name, ca.Issue(t, certstest.OptDNSNames(name+".test")))
+	secret.Labels = map[string]string{"tier": "edge"}
+	return secret
+}
+
+func newSource(k *certstest.KubeAPI, opts ...Option) *Source {
+	opts = append([]Option{
+		OptNamespace("web"),
+		OptLabelSelector("tier=edge"),
+		OptRetryInterval(10 * time.Millisecond),
+	}, opts...)
+	return New(k.URL, opts...)
+}
+
+// requests splits the fake's requests into the number of lists and the
+// resource versions watches started from.
+func requests(t *testing.T, k *certstest.KubeAPI) (lists int, watches []int) {
+	t.Helper()
+	for _, uri := range k.Requests() {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		if query.Get("watch") != "true" {
+			if len(query.Get("continue")) == 0 {
+				lists++
+			}
+			continue
+		}
+		rv, err := strconv.Atoi(query.Get("resourceVersion"))
+		if err != nil {
+			t.Fatalf("watch %s: %v", uri, err)
+		}
+		watches = append(watches, rv)
+	}
+	return lists, watches
+}
+
+func TestSourceLoad(t *testing.T) {
+	ca := certstest.NewCA(t)
+	k := certstest.NewKubeAPI(t)
+	k.RequireToken("token")
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		k.Put(edgeSecret(t, ca, name))
+	}
+	k.Put(certstest.TLSSecret("web", "internal", ca.Issue(t, certstest.OptDNSNames("internal.test"))))
+	other := edgeSecret(t, ca, "other")
+	other.Namespace = "api"
+	k.Put(other)
+	broken := edgeSecret(t, ca, "broken")
+	delete(broken.Data, "tls.key")
+	k.Put(broken)
+
+	r, _ := certstest.NewReloader(t, certstest.NewDir(t), certs.OptReloaderSource(newSource(k, OptToken("token"), OptPageSize(2))))
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if certstest.Serving(r, name+".test") == nil {
+			t.Errorf("not serving %s", name)
+		}
+	}
+	for _, name := range []string{"internal", "other", "broken"} {
+		if leaf := certstest.Serving(r, name+".test"); leaf != nil {
+			t.Errorf("serving unselected secret %s", name)
+		}
+	}
+	if pages := len(k.Requests()); pages != 3 {
+		t.Errorf("listed in %d requests, want 3 pages of 2", pages)
+	}
+}
+
+func TestSourceWatch(t *testing.T) {
+	ca := certstest.NewCA(t)
+	k := certstest.NewKubeAPI(t)
+	first := edgeSecret(t, ca, "a")
+	k.Put(first)
+	r, rec := certstest.NewReloader(t, certstest.NewDir(t), certs.OptReloaderSource(newSource(k)))
+	certstest.Start(t, r)
+
+	k.Put(edgeSecret(t, ca, "b"))
+	if e := rec.Wait(t, certs.EventAdd, CertName("web", "b")); e.Trigger != certs.TriggerSource {
+		t.Errorf("add trigger = %s, want %s", e.Trigger, certs.TriggerSource)
+	}
+
+	second := edgeSecret(t, ca, "a")
+	k.Put(second)
+	rec.Wait(t, certs.EventReplace, CertName("web", "a"))
+	serving := certstest.Serving(r, "a.test")
+
+	broken := edgeSecret(t, ca, "a")
+	broken.Data["tls.crt"] = []byte("not a cert")
+	k.Put(broken)
+	// the watch applies changes in order, so c being added means the broken
+	// update was skipped
+	k.Put(edgeSecret(t, ca, "c"))
+	rec.Wait(t, certs.EventAdd, CertName("web", "c"))
+	if leaf := certstest.Serving(r, "a.test"); leaf == nil || !leaf.Equal(serving) {
+		t.Errorf("broken update replaced the served cert")
+	}
+
+	k.Delete("web", "b")
+	rec.Wait(t, certs.EventEvict, CertName("web", "b"))
+	unlabelled := edgeSecret(t, ca, "c")
+	unlabelled.Labels = nil
+	k.Put(unlabelled)
+	rec.Wait(t, certs.EventEvict, CertName("web", "c"))
+	for _, name := range []string{"b", "c"} {
+		if certstest.Serving(r, name+".test") != nil {
+			t.Errorf("serving %s after it left the selection", name)
+		}
+	}
+}
+
+func TestSourceRelistsExpiredVersion(t *testing.T) {
+	ca := certstest.NewCA(t)
+	k := certstest.NewKubeAPI(t)
+	k.Put(edgeSecret(t, ca, "a"))
+	k.Put(edgeSecret(t, ca, "b"))
+	r, rec := certstest.NewReloader(t, certstest.NewDir(t), certs.OptReloaderSource(newSource(k, OptToken("token"))))
+	certstest.Start(t, r)
+	// an event through the watch shows it is streaming
+	k.Put(edgeSecret(t, ca, "ready"))
+	rec.Wait(t, certs.EventAdd, CertName("web", "ready"))
+	listed, _ := requests(t, k)
+
+	// keep the source from watching while b is deleted and the history of
+	// the deletion is compacted away
+	k.RequireToken("other")
+	k.CloseWatches()
+	k.Delete("web", "b")
+	k.Put(edgeSecret(t, ca, "c"))
+	k.Compact()
+	k.RequireToken("")
+
+	rec.Wait(t, certs.EventEvict, CertName("web", "b"))
+	rec.Wait(t, certs.EventAdd, CertName("web", "c"))
+	if certstest.Serving(r, "a.test") == nil {
+		t.Error("relisting dropped a")
+	}
+	if lists, _ := requests(t, k); lists != listed+1 {
+		t.Errorf("listed %d times, want a relist after the 410", lists)
+	}
+}
+
+func TestSourceBookmark(t *testing.T) {
+	ca := certstest.NewCA(t)
+	k := certstest.NewKubeAPI(t)
+	r, rec := certstest.NewReloader(t, certstest.NewDir(t), certs.OptReloaderSource(newSource(k)))
+	certstest.Start(t, r)
+	k.Put(edgeSecret(t, ca, "ready"))
+	rec.Wait(t, certs.EventAdd, CertName("web", "ready"))
+	listed, _ := requests(t, k)
+
+	// changes the source does not select only reach it through bookmarks
+	k.Put(certstest.TLSSecret("api", "other", ca.Issue(t)))
+	k.Bookmark()
+	k.CloseWatches()
+
+	var watches []int
+	certstest.Eventually(t, func() bool {
+		_, watches = requests(t, k)
+		return len(watches) >= 2
+	})
+	if want := watches[0] + 2; watches[1] != want {
+		t.Errorf("rewatched from %d, want the bookmark's %d", watches[1], want)
+	}
+	if lists, _ := requests(t, k); lists != listed {
+		t.Errorf("relisted %d times after a bookmark", lists-listed)
+	}
+}
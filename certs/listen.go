@@ -0,0 +1,95 @@
+package certs
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultSocketMode os.FileMode = 0600
+)
+
+type socketConfig struct {
+	mode os.FileMode
+	gid  int
+}
+
+type SocketOption func(*socketConfig)
+
+// OptSocketMode sets the socket's permissions, such as 0660 to let the
+// socket's group connect.
+func OptSocketMode(mode os.FileMode) SocketOption {
+	return func(c *socketConfig) {
+		c.mode = mode.Perm()
+	}
+}
+
+// OptSocketGroup sets the socket's group, so a process running as another
+// user in the group can connect when the mode allows it.
+func OptSocketGroup(gid int) SocketOption {
+	return func(c *socketConfig) {
+		c.gid = gid
+	}
+}
+
+// ListenUnix listens on a unix socket, removing a stale socket file first. By
+// default only its owner can connect. The socket is bound in a private
+// directory and moved into place once its group and mode are set, so nobody
+// else can connect in between, and it is removed when the listener is closed.
+func ListenUnix(path string, opts ...SocketOption) (net.Listener, error) {
+	cfg := socketConfig{mode: DefaultSocketMode, gid: -1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+	if cfg.gid >= 0 {
+		if err := os.Chown(tmp, -1, cfg.gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(tmp, cfg.mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+type unixListener struct {
+	*net.UnixListener
+	addr *net.UnixAddr
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *unixListener) Close() error {
+	if err := l.UnixListener.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(l.addr.Name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
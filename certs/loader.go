@@ -0,0 +1,419 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Loader holds the settings used to read certificate pairs from disk. The zero
+// value and a nil Loader load plaintext pairs.
+type Loader struct {
+	Log        Logger
+	Passphrase PassphraseProvider
+	Policy     *Policy
+	Clock      Clock
+	// Signers are the providers of external keys by name.
+	Signers map[string]SignerProvider
+	// StageNotYetValid loads certs before their NotBefore, which the
+	// reloader's cache stages until then. Otherwise they fail to load.
+	StageNotYetValid bool
+}
+
+var (
+	certFileExts   = []string{".crt", ".cer", ".der"}
+	pkcs12FileExts = []string{".p12", ".pfx"}
+)
+
+// pairNameForFile returns the name of the pair the file belongs to, or an
+// empty string. Metadata and SCT sidecars only belong to a pair whose cert
+// file exists, so other YAML and JSON files in a directory are not pairs.
+func pairNameForFile(file string) string {
+	name, ft := FilePairNameAndType(file)
+	if (ft == FileTypeMetadata || ft == FileTypeSCT) && !hasCertFile(name) {
+		return ""
+	}
+	return name
+}
+
+func hasCertFile(name string) bool {
+	for _, ext := range append(certFileExts, pkcs12FileExts...) {
+		if fileExists(name + ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Loader) LoadCertPair(name string, mod time.Time) (*Cert, error) {
+	keyFile := name + ".key"
+	if fileExists(keyFile) {
+		for _, ext := range certFileExts {
+			if fileExists(name + ext) {
+				return l.LoadCertFiles(name, name+ext, keyFile, mod)
+			}
+		}
+	}
+	for _, ext := range pkcs12FileExts {
+		if fileExists(name + ext) {
+			return l.LoadCertFiles(name, name+ext, name+ext, mod)
+		}
+	}
+	return l.LoadCertFiles(name, name+".crt", keyFile, mod)
+}
+
+func (l *Loader) LoadCertFiles(name, certFile, keyFile string, mod time.Time) (*Cert, error) {
+	cStat, err := os.Stat(certFile)
+	if err != nil {
+		return nil, err
+	}
+	kStat, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(certFile, filepath.Ext(certFile))
+	md, mdFile, err := LoadMetadata(base)
+	if err != nil {
+		return nil, err
+	}
+	scts, sctFile, err := LoadSCTs(base)
+	if errors.Is(err, ErrInvalidSCT) {
+		logger.MaybeWarningf(l.Log, "Not serving invalid SCTs for cert pair %s: %v", name, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if mod.After(cStat.ModTime()) && mod.After(kStat.ModTime()) && (md == nil || mod.After(mdFile.Mod)) && (scts == nil || mod.After(sctFile.Mod)) {
+		return nil, nil
+	}
+
+	var cert tls.Certificate
+	if _, ft := FilePairNameAndType(certFile); ft == FileTypePKCS12 {
+		cert, err = l.loadPKCS12(certFile)
+	} else {
+		cert, err = l.loadKeyPair(certFile, keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkCert(name, &cert); err != nil {
+		return nil, err
+	}
+	cert.SignedCertificateTimestamps = scts
+
+	return &Cert{
+		Certificate: cert,
+		Name:        name,
+		CertFile: File{
+			Path: certFile,
+			Mod:  cStat.ModTime(),
+		},
+		KeyFile: File{
+			Path: keyFile,
+			Mod:  kStat.ModTime(),
+		},
+		MetadataFile: mdFile,
+		Metadata:     md,
+		SCTFile:      sctFile,
+		Loaded:       time.Now(), // compared to file mod times so not from the clock
+		loader:       l,
+	}, nil
+}
+
+// LoadCertPEM parses a pair held in memory, such as one read from an API,
+// with the same checks as pairs on disk. The name identifies the key to the
+// passphrase provider.
+func (l *Loader) LoadCertPEM(name string, certPEM, keyPEM []byte) (*Cert, error) {
+	cert, err := l.pemKeyPair(name, certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkCert(name, &cert); err != nil {
+		return nil, err
+	}
+	return &Cert{
+		Certificate: cert,
+		Name:        name,
+		Loaded:      time.Now(),
+		loader:      l,
+	}, nil
+}
+
+func (l *Loader) pemKeyPair(name string, certPEM, keyPEM []byte) (tls.Certificate, error) {
+	if ref, ok := parseSignerRef(name, keyPEM); ok {
+		return l.signerKeyPair(certPEM, ref)
+	}
+	keyPEM, err := l.decryptKeyPEM(name, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return cert, nil
+}
+
+// checkCert parses the leaf and rejects expired certs and certs violating the
+// policy.
+func (l *Loader) checkCert(name string, cert *tls.Certificate) error {
+	if len(cert.Certificate) == 0 {
+		return fmt.Errorf("no certs parsed")
+	}
+	xcert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = xcert
+	if err := l.checkPolicy(name, *cert); err != nil {
+		return err
+	}
+	now := l.now()
+	if now.After(cert.Leaf.NotAfter) || (now.Before(cert.Leaf.NotBefore) && !l.stageNotYetValid()) {
+		return fmt.Errorf("invalid cert parsed")
+	}
+	return nil
+}
+
+func (l *Loader) LoadDirectoryCerts(ctx context.Context, dir string) ([]*Cert, error) {
+	files := map[string]bool{}
+	err := filepath.WalkDir(dir, func(path string, info fs.DirEntry, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+		name := pairNameForFile(path)
+		if len(name) == 0 {
+			return nil
+		}
+		files[name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make([]*Cert, 0, len(files))
+	for name := range files {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		cert, err := l.LoadCertPair(name, time.Time{})
+		if err != nil {
+			l.skipped(name, err)
+			continue
+		}
+		if cert == nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func (l *Loader) loadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	certPEM, err := readCertPEM(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if ref, ok := parseSignerRef(keyFile, keyData); ok {
+		return l.signerKeyPair(certPEM, ref)
+	}
+	keyPEM, err := l.keyPEM(keyFile, keyData)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func (l *Loader) loadPKCS12(file string) (tls.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var password []byte
+	if l != nil && l.Passphrase != nil {
+		password, err = l.Passphrase.Passphrase(file)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: reading passphrase: %w", file, err)
+		}
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, string(password))
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) && len(password) > 0 {
+		key, leaf, chain, err = pkcs12.DecodeChain(data, "")
+	}
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		if l == nil || l.Passphrase == nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %w", file, ErrPassphraseRequired)
+		}
+		return tls.Certificate{}, fmt.Errorf("%s: %w", file, ErrIncorrectPassphrase)
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %w", file, err)
+	}
+	if !publicKeysEqual(leaf.PublicKey, publicKeyOf(key)) {
+		return tls.Certificate{}, fmt.Errorf("%s: private key does not match certificate", file)
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range chain {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+func readCertPEM(certFile string) ([]byte, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	if isPEM(data) {
+		return data, nil
+	}
+	certs, err := x509.ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a PEM or DER certificate: %w", certFile, err)
+	}
+	var buf []byte
+	for _, cert := range certs {
+		buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return buf, nil
+}
+
+func (l *Loader) keyPEM(keyFile string, data []byte) ([]byte, error) {
+	if isPEM(data) {
+		return l.decryptKeyPEM(keyFile, data)
+	}
+	der, err := l.parseKeyDER(keyFile, data)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// parseKeyDER returns the plaintext DER of a DER encoded key, decrypting it if
+// it is an encrypted PKCS#8 key.
+func (l *Loader) parseKeyDER(keyFile string, data []byte) ([]byte, error) {
+	if _, err := parsePrivateKey(data); err == nil {
+		return data, nil
+	}
+	if isEncryptedPKCS8(data) {
+		return l.decryptKey(keyFile, data)
+	}
+	return nil, fmt.Errorf("%s: not a PEM or DER private key", keyFile)
+}
+
+func (l *Loader) decryptKeyPEM(keyFile string, data []byte) ([]byte, error) {
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return data, nil
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			der, err := l.decryptKey(keyFile, block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") && strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+			return nil, fmt.Errorf("%s: legacy encrypted PEM keys are not supported, convert to encrypted PKCS#8", keyFile)
+		}
+	}
+}
+
+func (l *Loader) decryptKey(keyFile string, der []byte) ([]byte, error) {
+	if l == nil || l.Passphrase == nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, ErrPassphraseRequired)
+	}
+	passphrase, err := l.Passphrase.Passphrase(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading passphrase: %w", keyFile, err)
+	}
+	plain, err := decryptPKCS8(der, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return plain, nil
+}
+
+func (l *Loader) skipped(name string, err error) {
+	if l == nil || os.IsNotExist(err) {
+		return
+	}
+	logger.MaybeWarningf(l.Log, "Skipping cert pair %s: %v", name, err)
+}
+
+func isPEM(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (l *Loader) checkPolicy(name string, cert tls.Certificate) error {
+	if l == nil || l.Policy == nil {
+		return nil
+	}
+	chain := make([]*x509.Certificate, 0, len(cert.Certificate))
+	chain = append(chain, cert.Leaf)
+	for _, der := range cert.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, c)
+	}
+	return l.Policy.Check(name, chain)
+}
+
+func (l *Loader) stageNotYetValid() bool {
+	return l != nil && l.StageNotYetValid
+}
+
+func (l *Loader) now() time.Time {
+	if l == nil {
+		return time.Now()
+	}
+	return clockOrSystem(l.Clock).Now()
+}
@@ -0,0 +1,78 @@
+package certs
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	metadataFileExts = []string{".yaml", ".yml", ".json"}
+)
+
+// Metadata is operational data attached to a pair by a `<name>.yaml` or
+// `<name>.json` sidecar file. Such files are only read as metadata when the
+// pair's cert file exists. Aliases are only served if the certificate is
+// valid for them.
+type Metadata struct {
+	Owner    string            `json:"owner" yaml:"owner"`
+	Priority int               `json:"priority" yaml:"priority"`
+	ALPN     []string          `json:"alpn" yaml:"alpn"`
+	Aliases  []string          `json:"aliases" yaml:"aliases"`
+	Disabled bool              `json:"disabled" yaml:"disabled"`
+	Labels   map[string]string `json:"labels" yaml:"labels"`
+}
+
+func (m *Metadata) IsDisabled() bool {
+	return m != nil && m.Disabled
+}
+
+func (m *Metadata) GetPriority() int {
+	if m == nil {
+		return 0
+	}
+	return m.Priority
+}
+
+// AllowsALPN reports whether the cert may be served to a client offering the
+// protocols. Certs restricted to ALPN protocols are only served to clients
+// offering one of them.
+func (m *Metadata) AllowsALPN(protos []string) bool {
+	if m == nil || len(m.ALPN) == 0 {
+		return true
+	}
+	for _, allowed := range m.ALPN {
+		for _, proto := range protos {
+			if allowed == proto {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadMetadata reads the sidecar for the pair name, returning nil metadata if
+// there is none.
+func LoadMetadata(name string) (*Metadata, File, error) {
+	for _, ext := range metadataFileExts {
+		path := name + ext
+		stat, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, File{}, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, File{}, err
+		}
+		var md Metadata
+		if err := yaml.Unmarshal(data, &md); err != nil {
+			return nil, File{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return &md, File{Path: path, Mod: stat.ModTime()}, nil
+	}
+	return nil, File{}, nil
+}
@@ -0,0 +1,227 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PairMode string
+
+const (
+	PairModeFileName  PairMode = "filename"
+	PairModePublicKey PairMode = "publickey"
+)
+
+const (
+	maxPairFileSize = 1 << 20
+)
+
+// Orphans are the certificate and key files found while pairing by public key
+// that had no matching counterpart.
+type Orphans struct {
+	Certs []string
+	Keys  []string
+}
+
+func (o *Orphans) Len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.Certs) + len(o.Keys)
+}
+
+type pairFile struct {
+	Path string
+	Type FileType
+	Key  crypto.PublicKey
+}
+
+func LoadDirectoryCertsByPublicKey(ctx context.Context, dir string) ([]*Cert, *Orphans, error) {
+	return (*Loader)(nil).LoadDirectoryCertsByPublicKey(ctx, dir)
+}
+
+func (l *Loader) LoadDirectoryCertsByPublicKey(ctx context.Context, dir string) ([]*Cert, *Orphans, error) {
+	var certFiles, keyFiles, bundles []pairFile
+	err := filepath.WalkDir(dir, func(path string, info fs.DirEntry, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if info.IsDir() || !info.Type().IsRegular() {
+			return nil
+		}
+		f, ok := l.readPairFile(path)
+		if !ok {
+			return nil
+		}
+		switch f.Type {
+		case FileTypeCert:
+			certFiles = append(certFiles, f)
+		case FileTypeKey:
+			keyFiles = append(keyFiles, f)
+		case FileTypePKCS12:
+			bundles = append(bundles, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pairs, orphans := matchByPublicKey(certFiles, keyFiles)
+	for _, f := range bundles {
+		name := strings.TrimSuffix(f.Path, filepath.Ext(f.Path))
+		if _, has := pairs[name]; has {
+			name = f.Path
+		}
+		pairs[name] = [2]string{f.Path, f.Path}
+	}
+	certs := make([]*Cert, 0, len(pairs))
+	for name, pair := range pairs {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		default:
+		}
+
+		cert, err := l.LoadCertFiles(name, pair[0], pair[1], time.Time{})
+		if err != nil {
+			l.skipped(name, err)
+			continue
+		}
+		if cert == nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs, orphans, nil
+}
+
+func matchByPublicKey(certFiles, keyFiles []pairFile) (map[string][2]string, *Orphans) {
+	pairs := make(map[string][2]string, len(certFiles))
+	orphans := &Orphans{}
+	usedKeys := make(map[string]bool, len(keyFiles))
+	for _, cf := range certFiles {
+		matched := false
+		for _, kf := range keyFiles {
+			if !publicKeysEqual(cf.Key, kf.Key) {
+				continue
+			}
+			name := strings.TrimSuffix(cf.Path, filepath.Ext(cf.Path))
+			if _, has := pairs[name]; has {
+				name = cf.Path
+			}
+			pairs[name] = [2]string{cf.Path, kf.Path}
+			usedKeys[kf.Path] = true
+			matched = true
+			break
+		}
+		if !matched {
+			orphans.Certs = append(orphans.Certs, cf.Path)
+		}
+	}
+	for _, kf := range keyFiles {
+		if !usedKeys[kf.Path] {
+			orphans.Keys = append(orphans.Keys, kf.Path)
+		}
+	}
+	sort.Strings(orphans.Certs)
+	sort.Strings(orphans.Keys)
+	return pairs, orphans
+}
+
+func (l *Loader) readPairFile(path string) (pairFile, bool) {
+	stat, err := os.Stat(path)
+	if err != nil || stat.Size() > maxPairFileSize {
+		return pairFile{}, false
+	}
+	if _, ft := FilePairNameAndType(path); ft == FileTypePKCS12 {
+		return pairFile{Path: path, Type: FileTypePKCS12}, true
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pairFile{}, false
+	}
+	if !isPEM(data) {
+		return l.readDERFile(path, data)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return pairFile{}, false
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			return certPairFile(path, block.Bytes)
+		case block.Type == signerKeyBlockType:
+			signer, err := l.signer(signerRef(path, block))
+			if err != nil {
+				l.skipped(path, err)
+				return pairFile{}, false
+			}
+			return pairFile{Path: path, Type: FileTypeKey, Key: signer.Public()}, true
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			der, err := l.decryptKey(path, block.Bytes)
+			if err != nil {
+				l.skipped(path, err)
+				return pairFile{}, false
+			}
+			return keyPairFile(path, der)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			return keyPairFile(path, block.Bytes)
+		}
+	}
+}
+
+func (l *Loader) readDERFile(path string, data []byte) (pairFile, bool) {
+	if f, ok := certPairFile(path, data); ok {
+		return f, true
+	}
+	if !isEncryptedPKCS8(data) {
+		return keyPairFile(path, data)
+	}
+	der, err := l.decryptKey(path, data)
+	if err != nil {
+		l.skipped(path, err)
+		return pairFile{}, false
+	}
+	return keyPairFile(path, der)
+}
+
+func certPairFile(path string, der []byte) (pairFile, bool) {
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return pairFile{}, false
+	}
+	return pairFile{Path: path, Type: FileTypeCert, Key: leaf.PublicKey}, true
+}
+
+func keyPairFile(path string, der []byte) (pairFile, bool) {
+	key, err := parsePrivateKey(der)
+	if err != nil {
+		return pairFile{}, false
+	}
+	pub := publicKeyOf(key)
+	if pub == nil {
+		return pairFile{}, false
+	}
+	return pairFile{Path: path, Type: FileTypeKey, Key: pub}, true
+}
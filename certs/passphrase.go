@@ -0,0 +1,48 @@
+package certs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrPassphraseRequired  = errors.New("encrypted private key requires a passphrase provider")
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase for private key")
+)
+
+// PassphraseProvider supplies the passphrase used to decrypt an encrypted
+// private key. It is called every time the key is loaded so rotated
+// passphrases are picked up with the key.
+type PassphraseProvider interface {
+	Passphrase(keyFile string) ([]byte, error)
+}
+
+type PassphraseFunc func(keyFile string) ([]byte, error)
+
+func (f PassphraseFunc) Passphrase(keyFile string) ([]byte, error) {
+	return f(keyFile)
+}
+
+// PassphraseEnv reads the passphrase from the named environment variable.
+type PassphraseEnv string
+
+func (e PassphraseEnv) Passphrase(_ string) ([]byte, error) {
+	val, has := os.LookupEnv(string(e))
+	if !has {
+		return nil, fmt.Errorf("passphrase env var %s is not set", string(e))
+	}
+	return []byte(val), nil
+}
+
+// PassphraseFile reads the passphrase from a file, ignoring trailing newlines.
+type PassphraseFile string
+
+func (f PassphraseFile) Passphrase(_ string) ([]byte, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
@@ -0,0 +1,210 @@
+package certs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blend/go-sdk/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	pinPrefix = "sha256/"
+)
+
+var (
+	ErrPinMismatch = errors.New("no certificate matches the pinned public keys")
+)
+
+// PinSet is the SPKI SHA-256 pins accepted for a set of server names. Backup
+// pins are accepted like pins and are meant for keys not yet deployed, so the
+// server can rotate keys without breaking clients. ReportOnly sets only emit
+// events on mismatch.
+type PinSet struct {
+	Hosts      []string `json:"hosts" yaml:"hosts"`
+	Pins       []string `json:"pins" yaml:"pins"`
+	BackupPins []string `json:"backupPins" yaml:"backupPins"`
+	ReportOnly bool     `json:"reportOnly" yaml:"reportOnly"`
+
+	pins map[string]bool
+}
+
+// PinSets maps server names to pin sets. Hosts may be wildcards.
+type PinSets struct {
+	Sets []PinSet `json:"pinSets" yaml:"pinSets"`
+
+	byHost map[string]*PinSet
+}
+
+// PinError is returned for a peer chain that matches none of its pins.
+type PinError struct {
+	ServerName string
+	// Peer is the pins of the certificates checked.
+	Peer       []string
+	ReportOnly bool
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("%v for %s, peer pins: %s", ErrPinMismatch, e.ServerName, strings.Join(e.Peer, ", "))
+}
+
+func (e *PinError) Unwrap() error {
+	return ErrPinMismatch
+}
+
+// SPKIPin returns the pin of the certificate's public key in the
+// sha256/<base64> form used by pin files.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// LoadPinSets reads pin sets from a YAML or JSON file.
+func LoadPinSets(path string) (*PinSets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p PinSets
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *PinSets) compile() error {
+	p.byHost = make(map[string]*PinSet)
+	for i := range p.Sets {
+		ps := &p.Sets[i]
+		if len(ps.Hosts) == 0 {
+			return fmt.Errorf("pin set %d has no hosts", i)
+		}
+		if len(ps.Pins) == 0 {
+			return fmt.Errorf("pin set for %v has no pins", ps.Hosts)
+		}
+		ps.pins = make(map[string]bool)
+		for _, pin := range append(append([]string(nil), ps.Pins...), ps.BackupPins...) {
+			normalized, err := parsePin(pin)
+			if err != nil {
+				return fmt.Errorf("pin set for %v: %w", ps.Hosts, err)
+			}
+			ps.pins[normalized] = true
+		}
+		for _, host := range ps.Hosts {
+			host = NormalizeHostname(host)
+			if err := CheckWildcard(host); err != nil {
+				return fmt.Errorf("pin set for %v: %w", ps.Hosts, err)
+			}
+			if _, has := p.byHost[host]; has {
+				return fmt.Errorf("host %s has more than one pin set", host)
+			}
+			p.byHost[host] = ps
+		}
+	}
+	return nil
+}
+
+// parsePin accepts a base64 SHA-256 digest with or without the sha256/ prefix.
+func parsePin(pin string) (string, error) {
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), pinPrefix))
+	if err != nil || len(digest) != sha256.Size {
+		return "", fmt.Errorf("invalid pin %q, want sha256/<base64 digest>", pin)
+	}
+	return pinPrefix + base64.StdEncoding.EncodeToString(digest), nil
+}
+
+// For returns the pin set for the server name, or nil if it is not pinned.
+func (p *PinSets) For(serverName string) *PinSet {
+	if p == nil {
+		return nil
+	}
+	for _, name := range SNICandidates(serverName) {
+		if ps := p.byHost[name]; ps != nil {
+			return ps
+		}
+	}
+	return nil
+}
+
+// Check returns a PinError unless a certificate in one of the chains matches
+// a pin.
+func (ps *PinSet) Check(serverName string, chains [][]*x509.Certificate) error {
+	var peer []string
+	for _, chain := range chains {
+		for _, cert := range chain {
+			pin := SPKIPin(cert)
+			if ps.pins[pin] {
+				return nil
+			}
+			peer = append(peer, pin)
+		}
+	}
+	return &PinError{ServerName: serverName, Peer: peer, ReportOnly: ps.ReportOnly}
+}
+
+func (r *Reloader) loadPinSets(ctx context.Context) error {
+	pins, err := LoadPinSets(r.PinFile)
+	if err != nil {
+		return err
+	}
+	for _, ps := range pins.Sets {
+		if len(ps.BackupPins) == 0 {
+			logger.MaybeWarningfContext(ctx, r.Log, "Pin set for %v has no backup pins", ps.Hosts)
+		}
+	}
+	logger.MaybeDebugfContext(ctx, r.Log, "Loaded %d pin sets", len(pins.Sets))
+	r.pins.Store(pins)
+	return nil
+}
+
+func (r *Reloader) PinSets() *PinSets {
+	return r.pins.Load()
+}
+
+// VerifyPins is a VerifyConnection func that checks the server against the
+// current pin sets. Verified chains are checked when present, otherwise only
+// the leaf is, as unverified intermediates prove nothing. Mismatches emit an
+// EventPinFailure and fail the handshake unless the set is report only.
+func (r *Reloader) VerifyPins(cs tls.ConnectionState) error {
+	return r.checkPins(cs.ServerName, cs.VerifiedChains, cs.PeerCertificates)
+}
+
+func (r *Reloader) checkPins(serverName string, chains [][]*x509.Certificate, peer []*x509.Certificate) error {
+	ps := r.PinSets().For(serverName)
+	if ps == nil {
+		return nil
+	}
+	if len(chains) == 0 {
+		if len(peer) == 0 {
+			return errors.New("server presented no certificates")
+		}
+		chains = [][]*x509.Certificate{peer[:1]}
+	}
+	err := ps.Check(serverName, chains)
+	if err == nil {
+		return nil
+	}
+	r.emit(Event{
+		Type:    EventPinFailure,
+		Trigger: TriggerHandshake,
+		Name:    NormalizeHostname(serverName),
+		Time:    r.now(),
+		Err:     err,
+	})
+	if ps.ReportOnly {
+		logger.MaybeWarningf(r.Log, "Report only pin failure: %v", err)
+		return nil
+	}
+	logger.MaybeErrorf(r.Log, "Pin failure: %v", err)
+	return err
+}
@@ -0,0 +1,260 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/blend/go-sdk/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// HostPolicy is the TLS settings applied to handshakes for a set of server
+// names. Empty fields leave the base config untouched.
+type HostPolicy struct {
+	Hosts        []string `json:"hosts" yaml:"hosts"`
+	MinVersion   string   `json:"minVersion" yaml:"minVersion"`
+	MaxVersion   string   `json:"maxVersion" yaml:"maxVersion"`
+	CipherSuites []string `json:"cipherSuites" yaml:"cipherSuites"`
+	ClientAuth   string   `json:"clientAuth" yaml:"clientAuth"`
+	NextProtos   []string `json:"alpn" yaml:"alpn"`
+
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+	clientAuth   *tls.ClientAuthType
+}
+
+// Policies maps server names to host policies, falling back to Default.
+type Policies struct {
+	Default *HostPolicy  `json:"default" yaml:"default"`
+	Hosts   []HostPolicy `json:"hosts" yaml:"hosts"`
+
+	byHost map[string]*HostPolicy
+}
+
+// LoadPolicies reads policies from a YAML or JSON file.
+func LoadPolicies(path string) (*Policies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policies
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policies) compile() error {
+	if p.Default != nil {
+		if err := p.Default.compile(); err != nil {
+			return fmt.Errorf("default policy: %w", err)
+		}
+	}
+	p.byHost = make(map[string]*HostPolicy)
+	for i := range p.Hosts {
+		hp := &p.Hosts[i]
+		if len(hp.Hosts) == 0 {
+			return fmt.Errorf("policy %d has no hosts", i)
+		}
+		if err := hp.compile(); err != nil {
+			return fmt.Errorf("policy for %v: %w", hp.Hosts, err)
+		}
+		for _, host := range hp.Hosts {
+			host = strings.ToLower(host)
+			if _, has := p.byHost[host]; has {
+				return fmt.Errorf("host %s has more than one policy", host)
+			}
+			p.byHost[host] = hp
+		}
+	}
+	return nil
+}
+
+// For returns the policy for the server name using the same exact then
+// wildcard matching as the cache, or the default policy.
+func (p *Policies) For(serverName string) *HostPolicy {
+	if p == nil {
+		return nil
+	}
+	serverName = strings.ToLower(serverName)
+	if hp, has := p.byHost[serverName]; has {
+		return hp
+	}
+	if wildcard := WildcardFor(serverName); len(wildcard) > 0 {
+		if hp, has := p.byHost[wildcard]; has {
+			return hp
+		}
+	}
+	return p.Default
+}
+
+func (hp *HostPolicy) compile() error {
+	var err error
+	if hp.minVersion, err = parseTLSVersion(hp.MinVersion); err != nil {
+		return err
+	}
+	if hp.maxVersion, err = parseTLSVersion(hp.MaxVersion); err != nil {
+		return err
+	}
+	if hp.minVersion != 0 && hp.maxVersion != 0 && hp.minVersion > hp.maxVersion {
+		return fmt.Errorf("min version %s is greater than max version %s", hp.MinVersion, hp.MaxVersion)
+	}
+	hp.cipherSuites = nil
+	for _, name := range hp.CipherSuites {
+		id, err := parseCipherSuite(name)
+		if err != nil {
+			return err
+		}
+		hp.cipherSuites = append(hp.cipherSuites, id)
+	}
+	hp.clientAuth = nil
+	if len(hp.ClientAuth) > 0 {
+		auth, err := parseClientAuth(hp.ClientAuth)
+		if err != nil {
+			return err
+		}
+		hp.clientAuth = &auth
+	}
+	return nil
+}
+
+// Apply sets the policy's settings on the config.
+func (hp *HostPolicy) Apply(cfg *tls.Config) {
+	if hp == nil {
+		return
+	}
+	if hp.minVersion != 0 {
+		cfg.MinVersion = hp.minVersion
+	}
+	if hp.maxVersion != 0 {
+		cfg.MaxVersion = hp.maxVersion
+	}
+	if len(hp.cipherSuites) > 0 {
+		cfg.CipherSuites = hp.cipherSuites
+	}
+	if hp.clientAuth != nil {
+		cfg.ClientAuth = *hp.clientAuth
+	}
+	if len(hp.NextProtos) > 0 {
+		cfg.NextProtos = hp.NextProtos
+	}
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch strings.ToUpper(strings.TrimSpace(v)) {
+	case "":
+		return 0, nil
+	case "1.0", "TLS1.0", "TLS 1.0":
+		return tls.VersionTLS10, nil
+	case "1.1", "TLS1.1", "TLS 1.1":
+		return tls.VersionTLS11, nil
+	case "1.2", "TLS1.2", "TLS 1.2":
+		return tls.VersionTLS12, nil
+	case "1.3", "TLS1.3", "TLS 1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q", v)
+	}
+}
+
+func parseCipherSuite(name string) (uint16, error) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, nil
+		}
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		if cs.Name == name {
+			return 0, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+func parseClientAuth(v string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require", "require-any":
+		return tls.RequireAnyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown client auth %q", v)
+	}
+}
+
+func (r *Reloader) loadPolicies(ctx context.Context) error {
+	p, err := LoadPolicies(r.PolicyFile)
+	if err != nil {
+		return err
+	}
+	logger.MaybeInfofContext(ctx, r.Log, "Loaded %d host policies from %s", len(p.Hosts), r.PolicyFile)
+	r.policies.Store(p)
+	return nil
+}
+
+func (r *Reloader) Policies() *Policies {
+	return r.policies.Load()
+}
+
+// ConfigForClient returns a tls.Config.GetConfigForClient func that serves a
+// clone of base with the policy for the requested server name applied and
+// certificates, client CAs and session ticket keys from the reloader. Configs
+// are built once per policy and rebuilt when the policy, trust files or ticket
+// keys change.
+func (r *Reloader) ConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	var lock sync.Mutex
+	var built *Policies
+	var builtTrust *x509.CertPool
+	var builtTickets uint64
+	configs := make(map[*HostPolicy]*tls.Config)
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		policies := r.policies.Load()
+		trust := r.trust.Load()
+		tickets := r.ticketGen.Load()
+		hp := policies.For(hello.ServerName)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if built != policies || builtTrust != trust || builtTickets != tickets {
+			built, builtTrust, builtTickets = policies, trust, tickets
+			configs = make(map[*HostPolicy]*tls.Config)
+		}
+		if cfg, has := configs[hp]; has {
+			return cfg, nil
+		}
+		var cfg *tls.Config
+		if base != nil {
+			cfg = base.Clone()
+		} else {
+			cfg = &tls.Config{}
+		}
+		cfg.GetConfigForClient = nil
+		if cfg.GetCertificate == nil && len(cfg.Certificates) == 0 {
+			cfg.GetCertificate = r.GetCertificate
+		}
+		if cfg.ClientCAs == nil && trust != nil {
+			cfg.ClientCAs = trust
+		}
+		if keys := r.SessionTicketKeys(); len(keys) > 0 {
+			cfg.SetSessionTicketKeys(keys)
+		}
+		hp.Apply(cfg)
+		configs[hp] = cfg
+		return cfg, nil
+	}
+}
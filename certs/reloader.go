@@ -3,9 +3,15 @@ package certs
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blend/go-sdk/logger"
@@ -23,8 +29,58 @@ type Reloader struct {
 	Dirs           []string
 	ReloadInterval time.Duration
 	Watch          bool
+	Clock          Clock
+	PairMode       PairMode
+	DefaultCert    string
+	ConfigFile     string
+	Loader         Loader
+	Issuer         Issuer
+	RenewFraction  float64
+	RenewTimeout   time.Duration
+	RenewManaged   func(*Cert) bool
+	PolicyFile     string
+	TrustFiles     []string
+	SPIFFEBundles  map[string]string
+	SPIFFEID       string
+	ClientCert     string
+	CRLFiles       []string
+	CRLPolicy      CRLPolicy
+	PinFile        string
+
+	TicketKeyFile     string
+	TicketKeyRotation time.Duration
+
+	WatchFiles []WatchedFile
+	Sources    []Source
+
+	EventHandlers   []EventHandler
+	AuditLogs       []io.Writer
+	UnknownSNILimit int
+	HistoryLimit    int
+	EvictDelay      time.Duration
 
 	watcher *fsnotify.Watcher
+	files   []*WatchedFile
+
+	policies atomic.Pointer[Policies]
+	trust    atomic.Pointer[x509.CertPool]
+	bundles  atomic.Pointer[map[string]*x509.CertPool]
+	crls     atomic.Pointer[map[string]*CRL]
+	crlStats crlStats
+	pins     atomic.Pointer[PinSets]
+
+	renewing atomic.Bool
+
+	ticketLock    sync.Mutex
+	ticketKeys    [][32]byte
+	ticketFile    [][32]byte
+	ticketConfigs []*tls.Config
+	ticketGen     atomic.Uint64
+
+	unknownSNI  *sniTracker
+	sniEvents   sniEventQueue
+	defaultCert atomic.Pointer[string]
+	config      atomic.Pointer[Config]
 
 	running     bool
 	certs       *Cache
@@ -46,12 +102,31 @@ func NewReloader(ctx context.Context, opts ...ReloaderOption) (*Reloader, error)
 	for _, opt := range opts {
 		opt(r)
 	}
+	if r.Loader.Log == nil {
+		r.Loader.Log = r.Log
+	}
+	if r.Loader.Clock == nil {
+		r.Loader.Clock = r.Clock
+	}
+	r.Loader.StageNotYetValid = true
+	r.unknownSNI = newSNITracker(r.UnknownSNILimit)
+	r.setDefaultCert(r.DefaultCert)
+	for _, out := range r.AuditLogs {
+		r.EventHandlers = append(r.EventHandlers, NewAuditLog(out, r.Log).Handle)
+	}
+
+	if err := r.Loader.Policy.Validate(); err != nil {
+		return nil, err
+	}
 
 	sanitized, err := RemoveSubdirectories(r.Dirs)
 	if err != nil {
 		return nil, err
 	}
 	r.Dirs = sanitized
+	if err := r.registerFiles(); err != nil {
+		return nil, err
+	}
 	return r, r.initialize(ctx)
 }
 
@@ -74,7 +149,11 @@ func (r *Reloader) Stop() error {
 
 func (r *Reloader) GetCertificate(helo *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	server := helo.ServerName
-	cert := r.certs.GetSNI(server)
+	cert := r.certs.GetSNIForProtos(server, helo.SupportedProtos)
+	if cert == nil {
+		r.observeUnknownSNI(server)
+		cert = r.getDefaultCert()
+	}
 	if cert == nil {
 		return nil, fmt.Errorf("no cert for name %s", server)
 	}
@@ -100,6 +179,14 @@ func (r *Reloader) run(ctx context.Context) error {
 	defer cancel()
 	go func() { errs <- r.watch(r.runCtx) }()
 	go func() { errs <- r.processQueue(r.runCtx) }()
+	var sources sync.WaitGroup
+	for _, src := range r.Sources {
+		sources.Add(1)
+		go func(src Source) {
+			defer sources.Done()
+			r.watchSource(r.runCtx, src)
+		}(src)
+	}
 	r.Lock.Unlock()
 
 	var err1, err2 error
@@ -114,6 +201,7 @@ func (r *Reloader) run(ctx context.Context) error {
 	}
 
 	err2 = <-errs
+	sources.Wait()
 	close(errs)
 	close(stop)
 	logger.MaybeInfo(r.Log, "Reloader stopped")
@@ -131,6 +219,18 @@ func (r *Reloader) initialize(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	err = r.initializeFiles(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.initializeSources(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.initializeTicketKeys(ctx)
+	if err != nil {
+		return err
+	}
 
 	if r.Watch {
 		err := r.initializeWatch()
@@ -155,11 +255,38 @@ func (r *Reloader) initializeWatch() error {
 		if err != nil {
 			return err
 		}
+		err = r.watchSCTDirs(dir)
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range r.files {
+		err = r.watcher.Add(filepath.Dir(f.Path))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchSCTDirs watches the <name>.sct directories in the dir, as changes to
+// the SCTs inside them are not reported for the dir itself.
+func (r *Reloader) watchSCTDirs(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if path := filepath.Join(dir, entry.Name()); isSCTDir(path) {
+			if err := r.watcher.Add(path); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (r *Reloader) loadAllCerts(ctx context.Context) error {
+func (r *Reloader) loadAllCerts(ctx context.Context, trigger Trigger) error {
 	errs := make([]error, 0, len(r.Dirs))
 	for _, dir := range r.Dirs {
 		select {
@@ -168,22 +295,60 @@ func (r *Reloader) loadAllCerts(ctx context.Context) error {
 		default:
 		}
 
-		logger.MaybeDebugfContext(ctx, r.Log, "Loading certs for directory %s", dir)
-		certs, err := LoadDirectoryCerts(ctx, dir)
+		err := r.loadDirectory(ctx, dir, trigger)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
-		r.certs.Set(certs...)
 	}
 	return errors.Join(errs...)
 }
 
+func (r *Reloader) loadDirectory(ctx context.Context, dir string, trigger Trigger) error {
+	logger.MaybeDebugfContext(ctx, r.Log, "Loading certs for directory %s", dir)
+	switch r.PairMode {
+	case PairModePublicKey:
+		certs, orphans, err := r.Loader.LoadDirectoryCertsByPublicKey(ctx, dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range orphans.Certs {
+			logger.MaybeWarningfContext(ctx, r.Log, "No private key matches certificate %s", file)
+		}
+		for _, file := range orphans.Keys {
+			logger.MaybeWarningfContext(ctx, r.Log, "No certificate matches private key %s", file)
+		}
+		r.certs.setWithTrigger(trigger, certs...)
+		return nil
+	default:
+		certs, err := r.Loader.LoadDirectoryCerts(ctx, dir)
+		if err != nil {
+			return err
+		}
+		r.certs.setWithTrigger(trigger, certs...)
+		return nil
+	}
+}
+
+func (r *Reloader) dirFor(file string) string {
+	for _, dir := range r.Dirs {
+		if strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return dir
+		}
+	}
+	return ""
+}
+
 func (r *Reloader) initializeAllCerts(ctx context.Context) error {
 	if r.certs == nil {
 		r.certs = NewCache(r.Log)
+		r.certs.loader = &r.Loader
+		r.certs.handlers = r.EventHandlers
+		r.certs.HistoryLimit = r.HistoryLimit
+		r.certs.EvictDelay = r.EvictDelay
+		r.certs.Clock = r.Clock
 	}
-	err := r.loadAllCerts(ctx)
+	err := r.loadAllCerts(ctx, TriggerInitial)
 	if err != nil {
 		return err
 	}
@@ -202,7 +367,11 @@ func (r *Reloader) processQueue(ctx context.Context) error {
 		}
 		name := *val
 		logger.MaybeDebugfContext(ctx, r.Log, "Processing cert reload %s", name)
-		add, err := r.certs.Reload(name)
+		add, err := r.certs.reload(name, TriggerFSEvent)
+		if os.IsNotExist(err) {
+			logger.MaybeDebugfContext(ctx, r.Log, "Skipping incomplete cert pair %s: %v", name, err)
+			continue
+		}
 		if err != nil {
 			logger.MaybeErrorfContext(ctx, r.Log, "Error reloading cert pair %s: %v", name, err)
 			continue
@@ -224,9 +393,9 @@ func (r *Reloader) watch(ctx context.Context) error {
 	var tick <-chan time.Time
 	if r.ReloadInterval > 0 {
 		logger.MaybeInfofContext(ctx, r.Log, "Using reload interval %0.2f seconds", float64(r.ReloadInterval)/float64(time.Second))
-		ticker := time.NewTicker(r.ReloadInterval)
+		ticker := r.clock().NewTicker(r.ReloadInterval)
 		defer ticker.Stop()
-		tick = ticker.C
+		tick = ticker.C()
 	} else {
 		logger.MaybeInfoContext(ctx, r.Log, "Reload on interval disabled. This is NOT reccomended")
 		t := make(chan time.Time)
@@ -234,6 +403,27 @@ func (r *Reloader) watch(ctx context.Context) error {
 		defer close(t)
 	}
 
+	var renewTick <-chan time.Time
+	var renewals sync.WaitGroup
+	renewCtx, cancelRenewals := context.WithCancel(ctx)
+	defer renewals.Wait()
+	defer cancelRenewals()
+	if r.Issuer != nil {
+		logger.MaybeInfofContext(ctx, r.Log, "Renewing managed certs after %0.0f%% of their lifetime", r.renewFraction()*100)
+		ticker := r.clock().NewTicker(renewCheckInterval)
+		defer ticker.Stop()
+		renewTick = ticker.C()
+		r.startRenewal(renewCtx, &renewals)
+	}
+
+	var ticketTick <-chan time.Time
+	if r.TicketKeyRotation > 0 {
+		logger.MaybeInfofContext(ctx, r.Log, "Rotating session ticket keys every %v", r.TicketKeyRotation)
+		ticker := r.clock().NewTicker(r.TicketKeyRotation)
+		defer ticker.Stop()
+		ticketTick = ticker.C()
+	}
+
 	var fsevents chan fsnotify.Event
 	var fserrs chan error
 	if r.watcher != nil {
@@ -262,15 +452,25 @@ func (r *Reloader) watch(ctx context.Context) error {
 			}
 			logger.MaybeDebugfContext(ctx, r.Log, "Reloading all certs")
 			r.reloadQueue.Empty()
-			err := r.loadAllCerts(ctx)
+			err := r.loadAllCerts(ctx, TriggerInterval)
 			if err != nil {
 				logger.MaybeErrorfContext(ctx, r.Log, "Reload all error: %v", err)
 			}
+			r.certs.evictMissing(TriggerInterval)
+			r.reloadFiles(ctx)
 			if r.certs.Len() >= (2*r.reloadQueue.Cap())/3 {
 				logger.MaybeDebugfContext(ctx, r.Log, "Resizing queue for new certs len: %d cap: %d", r.certs.Len(), r.reloadQueue.Cap())
 				r.reloadQueue.Expand(2 * r.reloadQueue.Cap())
 			}
 			continue
+		case <-renewTick:
+			r.startRenewal(renewCtx, &renewals)
+			continue
+		case <-ticketTick:
+			if err := r.rotateTicketKeys(ctx); err != nil {
+				logger.MaybeErrorfContext(ctx, r.Log, "Error rotating session ticket keys: %v", err)
+			}
+			continue
 		case event, ok := <-fsevents:
 			if !ok {
 				return nil
@@ -291,16 +491,98 @@ func (r *Reloader) handleEvent(ctx context.Context, event fsnotify.Event) {
 	if r.reloadQueue == nil {
 		return
 	}
-	switch event.Op {
-	case fsnotify.Create, fsnotify.Write:
-		name := FilePairName(event.Name)
-		if len(name) == 0 {
+	removed := event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+	if !removed && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	if isTempFile(event.Name) {
+		return
+	}
+	if f := r.watchedFile(event.Name); f != nil {
+		if !removed {
+			r.reloadFile(ctx, f)
+		}
+		return
+	}
+	if dir, ok := sctDirEntry(event.Name); ok && len(r.dirFor(dir)) > 0 {
+		// a change inside a <name>.sct directory is a change to the pair's
+		// SCT sidecar
+		event, removed = fsnotify.Event{Name: dir, Op: fsnotify.Write}, false
+	} else if event.Has(fsnotify.Create) && isSCTDir(event.Name) {
+		if err := r.watcher.Add(event.Name); err != nil {
+			logger.MaybeErrorfContext(ctx, r.Log, "Error watching SCT directory %s: %v", event.Name, err)
+		}
+	}
+	if r.PairMode == PairModePublicKey {
+		r.handlePublicKeyEvent(ctx, event, removed)
+		return
+	}
+	name := pairNameForFile(event.Name)
+	if len(name) == 0 {
+		return
+	}
+	logger.MaybeDebugfContext(ctx, r.Log, "Got %s event for name %s pushing to write update", event.Op, name)
+	r.reloadQueue.Push(name)
+}
+
+func (r *Reloader) handlePublicKeyEvent(ctx context.Context, event fsnotify.Event, removed bool) {
+	if _, ft := FilePairNameAndType(event.Name); ft != FileTypeMetadata && ft != FileTypeSCT && !removed {
+		if _, ok := r.Loader.readPairFile(event.Name); !ok {
 			return
 		}
-		logger.MaybeDebugfContext(ctx, r.Log, "Got write event for name %s pushing to write update", name)
-		r.reloadQueue.Push(name)
+	}
+	abs, err := filepath.Abs(event.Name)
+	if err != nil {
 		return
-	default:
+	}
+	dir := r.dirFor(abs)
+	if len(dir) == 0 {
 		return
 	}
+	logger.MaybeDebugfContext(ctx, r.Log, "Got %s event for %s rescanning directory %s", event.Op, event.Name, dir)
+	err = r.loadDirectory(ctx, dir, TriggerFSEvent)
+	if err != nil {
+		logger.MaybeErrorfContext(ctx, r.Log, "Error rescanning directory %s: %v", dir, err)
+	}
+	if removed {
+		r.certs.evictMissing(TriggerFSEvent)
+	}
+}
+
+func (r *Reloader) clock() Clock {
+	return clockOrSystem(r.Clock)
+}
+
+func (r *Reloader) now() time.Time {
+	return r.clock().Now()
+}
+
+// setDefaultCert sets the pair served when no cert matches, accepting a pair
+// name or one of its files.
+func (r *Reloader) setDefaultCert(name string) {
+	if len(name) == 0 {
+		r.defaultCert.Store(nil)
+		return
+	}
+	name = pairName(name)
+	r.defaultCert.Store(&name)
+}
+
+// pairName returns the cert name for a pair name or one of its files.
+func pairName(name string) string {
+	if pair, ft := FilePairNameAndType(name); ft != FileTypeUnknown {
+		name = pair
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	return name
+}
+
+func (r *Reloader) getDefaultCert() *Cert {
+	name := r.defaultCert.Load()
+	if name == nil {
+		return nil
+	}
+	return r.certs.Get(*name)
 }
@@ -1,6 +1,10 @@
 package certs
 
-import "time"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type ReloaderOption func(*Reloader)
 
@@ -27,3 +31,206 @@ func OptReloaderLogger(log Logger) ReloaderOption {
 		r.Log = log
 	}
 }
+
+func OptReloaderPairMode(mode PairMode) ReloaderOption {
+	return func(r *Reloader) {
+		r.PairMode = mode
+	}
+}
+
+func OptReloaderPassphrase(p PassphraseProvider) ReloaderOption {
+	return func(r *Reloader) {
+		r.Loader.Passphrase = p
+	}
+}
+
+// OptReloaderSigner adds a provider for external keys referenced by key files
+// with the name as their Provider header.
+func OptReloaderSigner(name string, provider SignerProvider) ReloaderOption {
+	return func(r *Reloader) {
+		if r.Loader.Signers == nil {
+			r.Loader.Signers = make(map[string]SignerProvider)
+		}
+		r.Loader.Signers[name] = provider
+	}
+}
+
+func OptReloaderIssuer(issuer Issuer) ReloaderOption {
+	return func(r *Reloader) {
+		r.Issuer = issuer
+	}
+}
+
+func OptReloaderRenewFraction(fraction float64) ReloaderOption {
+	return func(r *Reloader) {
+		r.RenewFraction = fraction
+	}
+}
+
+// OptReloaderRenewTimeout bounds each renewal, including the issuer's
+// request, defaulting to DefaultRenewTimeout.
+func OptReloaderRenewTimeout(timeout time.Duration) ReloaderOption {
+	return func(r *Reloader) {
+		r.RenewTimeout = timeout
+	}
+}
+
+// OptReloaderRenewManaged sets which certs the issuer renews. No certs are
+// renewed without it.
+func OptReloaderRenewManaged(managed func(*Cert) bool) ReloaderOption {
+	return func(r *Reloader) {
+		r.RenewManaged = managed
+	}
+}
+
+func OptReloaderPolicyFile(path string) ReloaderOption {
+	return func(r *Reloader) {
+		r.PolicyFile = path
+	}
+}
+
+func OptReloaderTrustFiles(files ...string) ReloaderOption {
+	return func(r *Reloader) {
+		r.TrustFiles = files
+	}
+}
+
+func OptReloaderSPIFFEBundle(trustDomain, file string) ReloaderOption {
+	return func(r *Reloader) {
+		if r.SPIFFEBundles == nil {
+			r.SPIFFEBundles = make(map[string]string)
+		}
+		r.SPIFFEBundles[trustDomain] = file
+	}
+}
+
+func OptReloaderSPIFFEID(id string) ReloaderOption {
+	return func(r *Reloader) {
+		r.SPIFFEID = id
+	}
+}
+
+// OptReloaderClientCert sets the cert presented to servers, as a pair name, a
+// cert file or a SPIFFE ID.
+func OptReloaderClientCert(name string) ReloaderOption {
+	return func(r *Reloader) {
+		r.ClientCert = name
+	}
+}
+
+func OptReloaderCRLFiles(files ...string) ReloaderOption {
+	return func(r *Reloader) {
+		r.CRLFiles = files
+	}
+}
+
+func OptReloaderCRLPolicy(policy CRLPolicy) ReloaderOption {
+	return func(r *Reloader) {
+		r.CRLPolicy = policy
+	}
+}
+
+// OptReloaderTicketKeyFile shares session ticket keys through the file, which
+// is watched for keys written by other servers.
+func OptReloaderTicketKeyFile(file string) ReloaderOption {
+	return func(r *Reloader) {
+		r.TicketKeyFile = file
+	}
+}
+
+// OptReloaderTicketKeyRotation generates a new ticket key on the interval,
+// writing it to the ticket key file if there is one. Servers sharing the file
+// may all rotate: each merges the keys in the file with its own before
+// writing, and adopts the current key written by the others. Rotating on one
+// server and only reading the file on the rest avoids extra rotations.
+func OptReloaderTicketKeyRotation(interval time.Duration) ReloaderOption {
+	return func(r *Reloader) {
+		r.TicketKeyRotation = interval
+	}
+}
+
+func OptReloaderPolicy(policy *Policy) ReloaderOption {
+	return func(r *Reloader) {
+		r.Loader.Policy = policy
+	}
+}
+
+func OptReloaderEventHandler(handler EventHandler) ReloaderOption {
+	return func(r *Reloader) {
+		r.EventHandlers = append(r.EventHandlers, handler)
+	}
+}
+
+func OptReloaderAuditLog(out io.Writer) ReloaderOption {
+	return func(r *Reloader) {
+		r.AuditLogs = append(r.AuditLogs, out)
+	}
+}
+
+func OptReloaderUnknownSNILimit(limit int) ReloaderOption {
+	return func(r *Reloader) {
+		r.UnknownSNILimit = limit
+	}
+}
+
+// OptReloaderWatchFile reloads the file with the certs, letting other
+// packages hot-reload their own config through the reloader.
+func OptReloaderWatchFile(path string, load func(context.Context) error) ReloaderOption {
+	return func(r *Reloader) {
+		r.WatchFiles = append(r.WatchFiles, WatchedFile{Path: path, Load: load})
+	}
+}
+
+// OptReloaderSource adds a source of certs besides the reloader's directories.
+func OptReloaderSource(src Source) ReloaderOption {
+	return func(r *Reloader) {
+		r.Sources = append(r.Sources, src)
+	}
+}
+
+// OptReloaderHistoryLimit sets the number of versions retained per cert for
+// pinning and rollback.
+func OptReloaderHistoryLimit(limit int) ReloaderOption {
+	return func(r *Reloader) {
+		r.HistoryLimit = limit
+	}
+}
+
+// OptReloaderEvictDelay sets how long a pair's files must stay missing before
+// it is evicted. Defaults to DefaultEvictDelay.
+func OptReloaderEvictDelay(delay time.Duration) ReloaderOption {
+	return func(r *Reloader) {
+		r.EvictDelay = delay
+	}
+}
+
+// OptReloaderClock sets the clock used for expiry, staged activation and
+// reload scheduling.
+func OptReloaderClock(clock Clock) ReloaderOption {
+	return func(r *Reloader) {
+		r.Clock = clock
+	}
+}
+
+// OptReloaderDefaultCert serves the pair when no cert matches the server
+// name. The name may be the pair name or one of its files.
+func OptReloaderDefaultCert(name string) ReloaderOption {
+	return func(r *Reloader) {
+		r.DefaultCert = name
+	}
+}
+
+// OptReloaderConfigFile watches the config file the reloader was created
+// from, applying changes to the default cert.
+func OptReloaderConfigFile(path string) ReloaderOption {
+	return func(r *Reloader) {
+		r.ConfigFile = path
+	}
+}
+
+// OptReloaderPinFile sets the SPKI pin sets checked by client configs.
+func OptReloaderPinFile(path string) ReloaderOption {
+	return func(r *Reloader) {
+		r.PinFile = path
+	}
+}
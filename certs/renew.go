@@ -0,0 +1,329 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+const (
+	DefaultRenewFraction = 2.0 / 3.0
+	DefaultRenewTimeout  = 5 * time.Minute
+
+	renewCheckInterval = time.Minute
+	tempFileSuffix     = ".tmp"
+)
+
+// RenewDue reports whether the given fraction of the certificate's lifetime
+// has elapsed.
+func (c *Cert) RenewDue(now time.Time, fraction float64) bool {
+	if c == nil || c.Leaf == nil {
+		return false
+	}
+	lifetime := c.Leaf.NotAfter.Sub(c.Leaf.NotBefore)
+	due := c.Leaf.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+	return !now.Before(due)
+}
+
+func (r *Reloader) renewTimeout() time.Duration {
+	if r.RenewTimeout <= 0 {
+		return DefaultRenewTimeout
+	}
+	return r.RenewTimeout
+}
+
+func (r *Reloader) renewFraction() float64 {
+	if r.RenewFraction <= 0 || r.RenewFraction >= 1 {
+		return DefaultRenewFraction
+	}
+	return r.RenewFraction
+}
+
+// manages reports whether the cert is renewed, which is opt-in through
+// RenewManaged.
+func (r *Reloader) manages(cert *Cert) bool {
+	if r.Issuer == nil || r.RenewManaged == nil {
+		return false
+	}
+	return r.RenewManaged(cert)
+}
+
+// startRenewal renews due certs in the background so a slow issuer does not
+// hold up reloads, skipping the check while the last one is still running.
+func (r *Reloader) startRenewal(ctx context.Context, wg *sync.WaitGroup) {
+	if !r.renewing.CompareAndSwap(false, true) {
+		logger.MaybeDebugfContext(ctx, r.Log, "Skipping renewal check, the last one is still running")
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer r.renewing.Store(false)
+		r.renewAll(ctx)
+	}()
+}
+
+func (r *Reloader) renewAll(ctx context.Context) {
+	now := r.now()
+	fraction := r.renewFraction()
+	for _, cert := range r.certs.All() {
+		if len(cert.Source) > 0 || !r.manages(cert) || !cert.RenewDue(now, fraction) {
+			continue
+		}
+		if r.certs.Staged(cert.Name) != nil {
+			continue
+		}
+		logger.MaybeInfofContext(ctx, r.Log, "Renewing cert %s expiring %s", cert.Name, cert.Leaf.NotAfter.Format(time.RFC3339))
+		renewCtx, cancel := context.WithTimeout(ctx, r.renewTimeout())
+		err := r.renew(renewCtx, cert)
+		cancel()
+		if err != nil {
+			logger.MaybeErrorfContext(ctx, r.Log, "Error renewing cert %s: %v", cert.Name, err)
+		}
+	}
+}
+
+func (r *Reloader) renew(ctx context.Context, cert *Cert) error {
+	if _, ft := FilePairNameAndType(cert.CertFile.Path); ft == FileTypePKCS12 {
+		return errors.New("renewal of PKCS#12 bundles is not supported")
+	}
+	if cert.hasExternalKey() {
+		return errors.New("renewal of certs with external keys is not supported")
+	}
+	key, err := generateKeyLike(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        cert.Leaf.Subject,
+		DNSNames:       cert.Leaf.DNSNames,
+		IPAddresses:    cert.Leaf.IPAddresses,
+		URIs:           cert.Leaf.URIs,
+		EmailAddresses: cert.Leaf.EmailAddresses,
+	}, key)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return err
+	}
+	chain, err := r.Issuer.Issue(ctx, csr)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		return errors.New("issuer returned an empty chain")
+	}
+	if !publicKeysEqual(chain[0].PublicKey, key.Public()) {
+		return errors.New("issued certificate does not match the requested key")
+	}
+
+	certData, err := encodeChainLike(cert.CertFile.Path, chain)
+	if err != nil {
+		return err
+	}
+	keyData, err := r.Loader.encodeKeyLike(cert.KeyFile.Path, key)
+	if err != nil {
+		return err
+	}
+	if err := writePairAtomic(cert.CertFile.Path, certData, cert.KeyFile.Path, keyData); err != nil {
+		return err
+	}
+	// the SCTs were issued for the old cert
+	if len(cert.SCTFile.Path) > 0 {
+		if err := os.RemoveAll(cert.SCTFile.Path); err != nil {
+			logger.MaybeWarningfContext(ctx, r.Log, "Error removing SCTs %s for renewed cert %s: %v", cert.SCTFile.Path, cert.Name, err)
+		}
+	}
+
+	nc, err := r.Loader.LoadCertFiles(cert.Name, cert.CertFile.Path, cert.KeyFile.Path, time.Time{})
+	if err != nil {
+		return err
+	}
+	if len(nc.SignedCertificateTimestamps) > 0 {
+		logger.MaybeWarningfContext(ctx, r.Log, "Not serving SCTs in %s for renewed cert %s until they are updated", nc.SCTFile.Path, cert.Name)
+		nc.SignedCertificateTimestamps = nil
+	}
+	r.certs.setWithTrigger(TriggerRenewal, nc)
+	logger.MaybeInfofContext(ctx, r.Log, "Renewed cert %s serial %s valid until %s", cert.Name, nc.Leaf.SerialNumber, nc.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func generateKeyLike(key crypto.PrivateKey) (crypto.Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.GenerateKey(rand.Reader, k.N.BitLen())
+	case *ecdsa.PrivateKey:
+		return ecdsa.GenerateKey(k.Curve, rand.Reader)
+	case ed25519.PrivateKey:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+}
+
+// encodeChainLike encodes the chain in the same encoding, PEM or DER, as the
+// existing certificate file.
+func encodeChainLike(certFile string, chain []*x509.Certificate) ([]byte, error) {
+	existing, err := os.ReadFile(certFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	der := len(existing) > 0 && !isPEM(existing)
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		if der {
+			buf.Write(cert.Raw)
+			continue
+		}
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeKeyLike encodes the key as PKCS#8 in the same encoding as the
+// existing key file, encrypting it when the existing key is encrypted.
+func (l *Loader) encodeKeyLike(keyFile string, key crypto.Signer) ([]byte, error) {
+	existing, err := os.ReadFile(keyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	isDER := len(existing) > 0 && !isPEM(existing)
+	encrypted := isEncryptedPKCS8(existing)
+	if !isDER {
+		block, _ := pem.Decode(existing)
+		encrypted = block != nil && block.Type == "ENCRYPTED PRIVATE KEY"
+	}
+	blockType := "PRIVATE KEY"
+	if encrypted {
+		if l == nil || l.Passphrase == nil {
+			return nil, fmt.Errorf("%s: %w", keyFile, ErrPassphraseRequired)
+		}
+		passphrase, err := l.Passphrase.Passphrase(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading passphrase: %w", keyFile, err)
+		}
+		der, err = encryptPKCS8(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		blockType = "ENCRYPTED PRIVATE KEY"
+	}
+	if isDER {
+		return der, nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+}
+
+// writePairAtomic writes both files before renaming them into place back to
+// back, cert last, restoring the old key if the cert cannot be replaced so
+// the new key is never left beside the old cert. A reload between the renames
+// fails on the mismatched key and keeps the old cert until the cert lands.
+func writePairAtomic(certFile string, certData []byte, keyFile string, keyData []byte) error {
+	oldKey, err := os.ReadFile(keyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	keyTmp, err := writeTempFile(keyFile, keyData, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := writeTempFile(certFile, certData, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTmp)
+
+	if err := os.Rename(keyTmp, keyFile); err != nil {
+		return err
+	}
+	if err := os.Rename(certTmp, certFile); err != nil {
+		var rerr error
+		if oldKey != nil {
+			rerr = writeFileAtomic(keyFile, oldKey, 0600)
+		} else {
+			rerr = os.Remove(keyFile)
+		}
+		if rerr != nil {
+			return errors.Join(err, fmt.Errorf("restoring %s: %w", keyFile, rerr))
+		}
+		return err
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := writeTempFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// writeTempFile writes the data to a synced temp file beside the path,
+// returning the temp file's name for the caller to rename or remove. Watch
+// events for temp files are ignored, see isTempFile.
+func writeTempFile(path string, data []byte, perm os.FileMode) (string, error) {
+	dir, base := filepath.Split(path)
+	if len(dir) == 0 {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*"+tempFileSuffix)
+	if err != nil {
+		return "", err
+	}
+	if err := writeTemp(tmp, data, perm); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// isTempFile reports whether the file is a temp file from writeTempFile.
+func isTempFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempFileSuffix)
+}
+
+func writeTemp(tmp *os.File, data []byte, perm os.FileMode) error {
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	return tmp.Close()
+}
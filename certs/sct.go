@@ -0,0 +1,204 @@
+package certs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	sctFileExt   = ".sct"
+	sctVersionV1 = 0
+	sctLogIDSize = 32
+	maxSCTSize   = 1 << 16
+)
+
+var (
+	ErrInvalidSCT = errors.New("invalid signed certificate timestamp")
+)
+
+// sctJSON is the add-chain response of a CT log.
+type sctJSON struct {
+	Version    uint8  `json:"sct_version"`
+	ID         string `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions string `json:"extensions"`
+	Signature  string `json:"signature"`
+}
+
+// LoadSCTs reads the signed certificate timestamps for the pair name from
+// <name>.sct, which is either a single SCT or a directory of .sct files.
+// Each SCT is the RFC 6962 binary encoding or a CT log JSON response. The
+// returned file's mod time is the latest of the SCT files. Invalid SCTs are
+// skipped and returned as an error wrapping ErrInvalidSCT alongside the
+// valid ones.
+func LoadSCTs(name string) ([][]byte, File, error) {
+	path := name + sctFileExt
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, File{}, nil
+	}
+	if err != nil {
+		return nil, File{}, err
+	}
+	file := File{Path: path, Mod: stat.ModTime()}
+	if !stat.IsDir() {
+		sct, err := readSCT(path)
+		if errors.Is(err, ErrInvalidSCT) {
+			return nil, file, err
+		}
+		if err != nil {
+			return nil, File{}, err
+		}
+		return [][]byte{sct}, file, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, File{}, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	var scts [][]byte
+	var invalid []error
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != sctFileExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, File{}, err
+		}
+		if info.ModTime().After(file.Mod) {
+			file.Mod = info.ModTime()
+		}
+		sct, err := readSCT(filepath.Join(path, entry.Name()))
+		if errors.Is(err, ErrInvalidSCT) {
+			invalid = append(invalid, err)
+			continue
+		}
+		if err != nil {
+			return nil, File{}, err
+		}
+		scts = append(scts, sct)
+	}
+	return scts, file, errors.Join(invalid...)
+}
+
+// isSCTDir reports whether the path is a <name>.sct directory.
+func isSCTDir(path string) bool {
+	if filepath.Ext(path) != sctFileExt {
+		return false
+	}
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
+}
+
+// sctDirEntry returns the <name>.sct directory holding the SCT file, if the
+// file is in one.
+func sctDirEntry(path string) (string, bool) {
+	dir := filepath.Dir(path)
+	if filepath.Ext(path) != sctFileExt || filepath.Ext(dir) != sctFileExt {
+		return "", false
+	}
+	return dir, true
+}
+
+func readSCT(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sct, err := decodeSCT(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sct, nil
+}
+
+func decodeSCT(data []byte) ([]byte, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return decodeSCTJSON(trimmed)
+	}
+	if err := checkSCT(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func decodeSCTJSON(data []byte) ([]byte, error) {
+	var js sctJSON
+	if err := json.Unmarshal(data, &js); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSCT, err)
+	}
+	id, err := base64.StdEncoding.DecodeString(js.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: log id: %v", ErrInvalidSCT, err)
+	}
+	if len(id) != sctLogIDSize {
+		return nil, fmt.Errorf("%w: log id is %d bytes, want %d", ErrInvalidSCT, len(id), sctLogIDSize)
+	}
+	extensions, err := base64.StdEncoding.DecodeString(js.Extensions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: extensions: %v", ErrInvalidSCT, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(js.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidSCT, err)
+	}
+	if len(extensions) > 0xffff {
+		return nil, fmt.Errorf("%w: extensions too long", ErrInvalidSCT)
+	}
+	sct := make([]byte, 0, 1+len(id)+8+2+len(extensions)+len(signature))
+	sct = append(sct, js.Version)
+	sct = append(sct, id...)
+	sct = binary.BigEndian.AppendUint64(sct, js.Timestamp)
+	sct = binary.BigEndian.AppendUint16(sct, uint16(len(extensions)))
+	sct = append(sct, extensions...)
+	sct = append(sct, signature...)
+	if err := checkSCT(sct); err != nil {
+		return nil, err
+	}
+	return sct, nil
+}
+
+// checkSCT validates the RFC 6962 structure of a v1 SCT:
+//
+//	version(1) log_id(32) timestamp(8) extensions<0..2^16-1>
+//	hash_alg(1) sig_alg(1) signature<0..2^16-1>
+func checkSCT(sct []byte) error {
+	if len(sct) > maxSCTSize {
+		return fmt.Errorf("%w: %d bytes is too long", ErrInvalidSCT, len(sct))
+	}
+	const header = 1 + sctLogIDSize + 8
+	if len(sct) < header+2 {
+		return fmt.Errorf("%w: too short", ErrInvalidSCT)
+	}
+	if sct[0] != sctVersionV1 {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSCT, sct[0])
+	}
+	rest := sct[header:]
+	extLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < extLen {
+		return fmt.Errorf("%w: truncated extensions", ErrInvalidSCT)
+	}
+	rest = rest[extLen:]
+	if len(rest) < 4 {
+		return fmt.Errorf("%w: truncated signature", ErrInvalidSCT)
+	}
+	sigLen := int(binary.BigEndian.Uint16(rest[2:]))
+	if len(rest[4:]) != sigLen {
+		return fmt.Errorf("%w: signature length %d does not match %d remaining bytes", ErrInvalidSCT, sigLen, len(rest[4:]))
+	}
+	if sigLen == 0 {
+		return fmt.Errorf("%w: empty signature", ErrInvalidSCT)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package sds
+
+import (
+	"crypto/tls"
+
+	"github.com/mat285/go-sdk/certs"
+	"google.golang.org/grpc"
+)
+
+type Option func(*Server)
+
+func OptLogger(log certs.Logger) Option {
+	return func(s *Server) {
+		s.Log = log
+	}
+}
+
+// OptSecretNames sets the secret names a cert is served under.
+func OptSecretNames(names func(*certs.Cert) []string) Option {
+	return func(s *Server) {
+		s.SecretNames = names
+	}
+}
+
+// OptValidationContext serves the CA bundles in the files, usually the
+// reloader's trust files, as a validation context secret.
+func OptValidationContext(name string, files ...string) Option {
+	return func(s *Server) {
+		if s.Validation == nil {
+			s.Validation = make(map[string][]string)
+		}
+		s.Validation[name] = append(s.Validation[name], files...)
+	}
+}
+
+// OptTLSConfig serves SDS over TLS, as is required on TCP listeners. Use a
+// config that requires and verifies client certificates, such as
+// certs.NewServerTLSConfig with tls.RequireAndVerifyClientCert.
+func OptTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.TLSConfig = cfg
+	}
+}
+
+func OptGRPCServerOptions(opts ...grpc.ServerOption) Option {
+	return func(s *Server) {
+		s.GRPCOptions = append(s.GRPCOptions, opts...)
+	}
+}
@@ -0,0 +1,255 @@
+package sds
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blend/go-sdk/logger"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resource "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/mat285/go-sdk/certs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	unixPrefix = "unix:"
+)
+
+var (
+	ErrInsecureListener = errors.New("sds: refusing to serve private keys without TLS on a non-unix listener")
+)
+
+// Server serves the reloader's certs to Envoy over the Secret Discovery
+// Service, pushing new versions to connected Envoys as certs and trust
+// bundles are reloaded. Certs are served as tls_certificate secrets named by
+// SecretNames, and the validation contexts as validation_context secrets.
+type Server struct {
+	Log certs.Logger
+	// SecretNames returns the secret names for a cert, defaulting to the base
+	// name of the pair.
+	SecretNames func(*certs.Cert) []string
+	// Validation maps validation context secret names to CA bundle files.
+	Validation map[string][]string
+	// TLSConfig secures SDS on listeners other than unix sockets, which are
+	// refused without it since secrets include private keys. It should
+	// require and verify client certificates.
+	TLSConfig   *tls.Config
+	GRPCOptions []grpc.ServerOption
+
+	lock    sync.Mutex
+	owners  map[string]string
+	secrets *cache.LinearCache
+}
+
+func New(opts ...Option) *Server {
+	s := &Server{
+		owners: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.secrets = cache.NewLinearCache(resource.SecretType, cache.WithLogger(cacheLogger{s.Log}))
+	return s
+}
+
+// ReloaderOptions feed the server from the reloader. They must be passed to
+// certs.NewReloader so the initial certs are served.
+func (s *Server) ReloaderOptions() []certs.ReloaderOption {
+	opts := []certs.ReloaderOption{certs.OptReloaderEventHandler(s.Handle)}
+	names := make([]string, 0, len(s.Validation))
+	for name := range s.Validation {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		load := s.validationLoader(name)
+		for _, file := range s.Validation[name] {
+			opts = append(opts, certs.OptReloaderWatchFile(file, load))
+		}
+	}
+	return opts
+}
+
+// Secrets returns the names of the secrets being served.
+func (s *Server) Secrets() []string {
+	resources := s.secrets.GetResources()
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Handle updates the served secrets for a cache event.
+func (s *Server) Handle(e certs.Event) {
+	switch e.Type {
+	case certs.EventAdd, certs.EventReplace:
+		s.update(e.Cert)
+	case certs.EventEvict:
+		s.remove(e.Name)
+	}
+}
+
+func (s *Server) update(cert *certs.Cert) {
+	if cert.Metadata.IsDisabled() {
+		s.remove(cert.Name)
+		return
+	}
+	chain, key, err := encodeCert(cert)
+	if err != nil {
+		logger.MaybeErrorf(s.Log, "Not serving cert %s over SDS: %v", cert.Name, err)
+		return
+	}
+	names := s.secretNames(cert)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	update := make(map[string]types.Resource, len(names))
+	for _, name := range names {
+		if owner, has := s.owners[name]; has && owner != cert.Name {
+			logger.MaybeWarningf(s.Log, "SDS secret %s from %s replaced by %s", name, owner, cert.Name)
+		}
+		s.owners[name] = cert.Name
+		update[name] = tlsCertificateSecret(name, chain, key)
+	}
+	var stale []string
+	for name, owner := range s.owners {
+		if owner == cert.Name && update[name] == nil {
+			stale = append(stale, name)
+			delete(s.owners, name)
+		}
+	}
+	if err := s.secrets.UpdateResources(update, stale); err != nil {
+		logger.MaybeErrorf(s.Log, "Error updating SDS secrets for %s: %v", cert.Name, err)
+	}
+}
+
+func (s *Server) remove(certName string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var stale []string
+	for name, owner := range s.owners {
+		if owner == certName {
+			stale = append(stale, name)
+			delete(s.owners, name)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	if err := s.secrets.UpdateResources(nil, stale); err != nil {
+		logger.MaybeErrorf(s.Log, "Error removing SDS secrets for %s: %v", certName, err)
+	}
+}
+
+func (s *Server) secretNames(cert *certs.Cert) []string {
+	if s.SecretNames != nil {
+		return s.SecretNames(cert)
+	}
+	return []string{filepath.Base(cert.Name)}
+}
+
+func (s *Server) validationLoader(name string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		bundle, err := readBundle(s.Validation[name]...)
+		if err != nil {
+			return err
+		}
+		logger.MaybeDebugfContext(ctx, s.Log, "Loaded SDS validation context %s", name)
+		return s.secrets.UpdateResource(name, validationContextSecret(name, bundle))
+	}
+}
+
+// Serve serves SDS on the listener until the context is cancelled. Listeners
+// other than unix sockets require TLSConfig.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	opts := s.GRPCOptions
+	if s.TLSConfig != nil {
+		if s.TLSConfig.ClientAuth < tls.RequireAndVerifyClientCert {
+			logger.MaybeWarningfContext(ctx, s.Log, "SDS on %s does not require client certificates, any client can fetch private keys", l.Addr())
+		}
+		opts = append([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(s.TLSConfig))}, opts...)
+	} else if l.Addr().Network() != "unix" {
+		return ErrInsecureListener
+	}
+	g := grpc.NewServer(opts...)
+	secretservice.RegisterSecretDiscoveryServiceServer(g, server.NewServer(ctx, fetchCache{s.secrets}, nil))
+	stop := context.AfterFunc(ctx, g.Stop)
+	defer stop()
+	logger.MaybeInfofContext(ctx, s.Log, "Serving SDS on %s", l.Addr())
+	if err := g.Serve(l); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Listen listens on a TCP address or, for addresses like unix:///path, a unix
+// socket only its owner can connect to.
+func Listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+	return certs.ListenUnix(strings.TrimPrefix(strings.TrimPrefix(address, unixPrefix), "//"))
+}
+
+// fetchCache answers FetchSecrets, which LinearCache leaves unimplemented,
+// with the requested secrets versioned by their contents.
+type fetchCache struct {
+	*cache.LinearCache
+}
+
+func (c fetchCache) Fetch(_ context.Context, req *cache.Request) (cache.Response, error) {
+	all := c.GetResources()
+	names := append([]string(nil), req.GetResourceNames()...)
+	sort.Strings(names)
+	var resources []types.ResourceWithTTL
+	var data []byte
+	for _, name := range names {
+		res, has := all[name]
+		if !has {
+			continue
+		}
+		marshaled, err := cache.MarshalResource(res)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, marshaled...)
+		resources = append(resources, types.ResourceWithTTL{Resource: res})
+	}
+	version := cache.HashResource(data)
+	if req.GetVersionInfo() == version {
+		return nil, &types.SkipFetchError{}
+	}
+	return &cache.RawResponse{Request: req, Version: version, Resources: resources}, nil
+}
+
+type cacheLogger struct {
+	log certs.Logger
+}
+
+func (l cacheLogger) Debugf(format string, args ...interface{}) {
+	logger.MaybeDebugf(l.log, format, args...)
+}
+
+func (l cacheLogger) Infof(format string, args ...interface{}) {
+	logger.MaybeDebugf(l.log, format, args...)
+}
+
+func (l cacheLogger) Warnf(format string, args ...interface{}) {
+	logger.MaybeWarningf(l.log, format, args...)
+}
+
+func (l cacheLogger) Errorf(format string, args ...interface{}) {
+	logger.MaybeErrorf(l.log, format, args...)
+}
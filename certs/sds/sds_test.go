@@ -0,0 +1,232 @@
+package sds
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	resource "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/mat285/go-sdk/certs"
+	"github.com/mat285/go-sdk/certs/certstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestServerDisabledCerts(t *testing.T) {
+	ca := certstest.NewCA(t)
+	dir := certstest.NewDir(t)
+	dir.WritePair("web", ca.Issue(t, certstest.OptDNSNames("web.test")))
+	dir.WritePair("api", ca.Issue(t, certstest.OptDNSNames("api.test")))
+	dir.Write("api.yaml", []byte("disabled: true\n"))
+
+	s := New()
+	r, rec := certstest.NewReloader(t, dir, s.ReloaderOptions()...)
+	if got := s.Secrets(); !reflect.DeepEqual(got, []string{"web"}) {
+		t.Fatalf("initial secrets = %v, want [web]", got)
+	}
+	certstest.Start(t, r)
+
+	writeSidecar(t, dir, "web.yaml", "disabled: true\n")
+	rec.Wait(t, certs.EventReplace, dir.Name("web"))
+	if got := s.Secrets(); len(got) != 0 {
+		t.Fatalf("secrets after disabling web = %v, want none", got)
+	}
+
+	writeSidecar(t, dir, "api.yaml", "disabled: false\n")
+	rec.Wait(t, certs.EventReplace, dir.Name("api"))
+	if got := s.Secrets(); !reflect.DeepEqual(got, []string{"api"}) {
+		t.Fatalf("secrets after enabling api = %v, want [api]", got)
+	}
+}
+
+// writeSidecar renames the file into place with a later mod time than the
+// pair was loaded at, which a write within the clock granularity may not get.
+func writeSidecar(t *testing.T, dir *certstest.Dir, file, data string) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), file)
+	if err := os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Now().Add(time.Second)
+	if err := os.Chtimes(tmp, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir.Path, file)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeRefusesInsecureTCP(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := New().Serve(context.Background(), l); !errors.Is(err, ErrInsecureListener) {
+		t.Fatalf("Serve on TCP without TLS = %v, want %v", err, ErrInsecureListener)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sds.sock")
+	l, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- New().Serve(ctx, l) }()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket mode = %v, want 0600", perm)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Serve = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		t.Errorf("socket %s not removed when serving stopped", path)
+	}
+}
+
+// serve serves SDS on a unix socket until the test ends and returns a client
+// connected to it.
+func serve(t *testing.T, s *Server) secretservice.SecretDiscoveryServiceClient {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sds.sock")
+	l, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := grpc.Dial("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return secretservice.NewSecretDiscoveryServiceClient(conn)
+}
+
+// secrets unpacks the secrets in a discovery response by name.
+func secrets(t *testing.T, res *discoveryv3.DiscoveryResponse) map[string]*tlsv3.Secret {
+	t.Helper()
+	out := make(map[string]*tlsv3.Secret)
+	for _, any := range res.Resources {
+		secret := new(tlsv3.Secret)
+		if err := any.UnmarshalTo(secret); err != nil {
+			t.Fatal(err)
+		}
+		out[secret.Name] = secret
+	}
+	return out
+}
+
+// checkCertificate checks the secret is a tls_certificate for the pair with a
+// key that matches it.
+func checkCertificate(t *testing.T, secret *tlsv3.Secret, pair *certstest.Pair) {
+	t.Helper()
+	tc := secret.GetTlsCertificate()
+	if tc == nil {
+		t.Fatalf("secret %s is not a tls_certificate", secret.GetName())
+	}
+	cert, err := tls.X509KeyPair(tc.CertificateChain.GetInlineBytes(), tc.PrivateKey.GetInlineBytes())
+	if err != nil {
+		t.Fatalf("secret %s: %v", secret.Name, err)
+	}
+	if !bytes.Equal(cert.Certificate[0], pair.Cert.Raw) {
+		t.Fatalf("secret %s is serving %x, want %s", secret.Name, cert.Certificate[0][:8], pair.Cert.Subject)
+	}
+}
+
+func TestServerEndToEnd(t *testing.T) {
+	ca := certstest.NewCA(t)
+	dir := certstest.NewDir(t)
+	first := ca.Issue(t, certstest.OptDNSNames("web.test"))
+	dir.WritePair("web", first)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, ca.CertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(OptValidationContext("trust", bundle))
+	r, rec := certstest.NewReloader(t, dir, s.ReloaderOptions()...)
+	certstest.Start(t, r)
+	client := serve(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node := &corev3.Node{Id: "envoy"}
+	fetched, err := client.FetchSecrets(ctx, &discoveryv3.DiscoveryRequest{
+		Node:          node,
+		TypeUrl:       resource.SecretType,
+		ResourceNames: []string{"web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCertificate(t, secrets(t, fetched)["web"], first)
+
+	stream, err := client.StreamSecrets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &discoveryv3.DiscoveryRequest{
+		Node:          node,
+		TypeUrl:       resource.SecretType,
+		ResourceNames: []string{"trust", "web"},
+	}
+	if err := stream.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := secrets(t, res)
+	checkCertificate(t, got["web"], first)
+	vc := got["trust"].GetValidationContext()
+	if vc == nil || !bytes.Equal(vc.TrustedCa.GetInlineBytes(), ca.CertPEM()) {
+		t.Fatalf("validation context = %v, want the CA bundle", got["trust"])
+	}
+
+	// acknowledge the version so the server pushes the next one
+	req.VersionInfo, req.ResponseNonce = res.VersionInfo, res.Nonce
+	if err := stream.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	second := ca.Issue(t, certstest.OptDNSNames("web.test"))
+	dir.Rotate("web", second)
+	rec.Wait(t, certs.EventReplace, dir.Name("web"))
+	if res, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	checkCertificate(t, secrets(t, res)["web"], second)
+}
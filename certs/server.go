@@ -0,0 +1,91 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+const (
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
+// Server is an HTTPS server whose certificates come from a Reloader that is
+// started and stopped with the server.
+type Server struct {
+	HTTP            *http.Server
+	Reloader        *Reloader
+	ShutdownTimeout time.Duration
+}
+
+func NewServer(r *Reloader, addr string, handler http.Handler, opts ...TLSConfigOption) *Server {
+	return &Server{
+		HTTP: &http.Server{
+			Addr:      addr,
+			Handler:   handler,
+			TLSConfig: NewServerTLSConfig(r, opts...),
+		},
+		Reloader: r,
+	}
+}
+
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	addr := s.HTTP.Addr
+	if len(addr) == 0 {
+		addr = ":https"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve runs the reloader and serves TLS on the listener until the context is
+// cancelled or the server fails, then shuts the server down and waits for the
+// reloader to stop.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reloaderDone := make(chan struct{})
+	go func() {
+		defer close(reloaderDone)
+		if err := s.Reloader.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.MaybeErrorfContext(ctx, s.Reloader.Log, "Cert reloader exited: %v", err)
+		}
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.HTTP.ServeTLS(l, "", "")
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		err = s.shutdown()
+		<-serveErr
+	case err = <-serveErr:
+	}
+	cancel()
+	<-reloaderDone
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) shutdown() error {
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.HTTP.Shutdown(ctx)
+}
@@ -0,0 +1,140 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	signerKeyBlockType = "SIGNER KEY"
+
+	SignerHeaderProvider = "Provider"
+	SignerHeaderKeyID    = "Key-Id"
+)
+
+var (
+	ErrUnknownSignerProvider = errors.New("no signer provider")
+)
+
+// SignerProvider supplies signers for private keys held outside the process,
+// such as by a signing agent, so the key is never readable by the server. It
+// is called every time the pair is loaded.
+type SignerProvider interface {
+	Signer(ref SignerRef) (crypto.Signer, error)
+}
+
+type SignerFunc func(ref SignerRef) (crypto.Signer, error)
+
+func (f SignerFunc) Signer(ref SignerRef) (crypto.Signer, error) {
+	return f(ref)
+}
+
+// SignerRef references an external key. It is read from a key file holding a
+// SIGNER KEY block in place of a private key:
+//
+//	-----BEGIN SIGNER KEY-----
+//	Provider: agent
+//	Key-Id: web
+//	-----END SIGNER KEY-----
+//
+// PublicKey is the certificate's public key when it is known, letting
+// providers find keys without a Key-Id.
+type SignerRef struct {
+	KeyFile   string
+	Provider  string
+	KeyID     string
+	Headers   map[string]string
+	PublicKey crypto.PublicKey
+}
+
+// EncodeSignerRef returns a key file referencing a key held by a provider.
+func EncodeSignerRef(provider, keyID string) []byte {
+	headers := map[string]string{SignerHeaderProvider: provider}
+	if len(keyID) > 0 {
+		headers[SignerHeaderKeyID] = keyID
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: signerKeyBlockType, Headers: headers})
+}
+
+// parseSignerRef returns the reference if the key data is a SIGNER KEY block.
+func parseSignerRef(keyFile string, data []byte) (SignerRef, bool) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != signerKeyBlockType {
+		return SignerRef{}, false
+	}
+	return signerRef(keyFile, block), true
+}
+
+func signerRef(keyFile string, block *pem.Block) SignerRef {
+	return SignerRef{
+		KeyFile:  keyFile,
+		Provider: block.Headers[SignerHeaderProvider],
+		KeyID:    block.Headers[SignerHeaderKeyID],
+		Headers:  block.Headers,
+	}
+}
+
+func (l *Loader) signer(ref SignerRef) (crypto.Signer, error) {
+	var provider SignerProvider
+	if l != nil {
+		provider = l.Signers[ref.Provider]
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("%s: %w %q", ref.KeyFile, ErrUnknownSignerProvider, ref.Provider)
+	}
+	signer, err := provider.Signer(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s: signer %s: %w", ref.KeyFile, ref.Provider, err)
+	}
+	if ref.PublicKey != nil && !publicKeysEqual(ref.PublicKey, signer.Public()) {
+		return nil, fmt.Errorf("%s: signer %s key does not match certificate", ref.KeyFile, ref.Provider)
+	}
+	return signer, nil
+}
+
+// signerKeyPair pairs the certificates with the referenced external key.
+func (l *Loader) signerKeyPair(certPEM []byte, ref SignerRef) (tls.Certificate, error) {
+	var cert tls.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.New("no certificates found")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	ref.PublicKey = leaf.PublicKey
+	signer, err := l.signer(ref)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert.Leaf = leaf
+	cert.PrivateKey = signer
+	return cert, nil
+}
+
+// hasExternalKey reports whether the cert's key is held outside the process.
+func (c *Cert) hasExternalKey() bool {
+	switch c.PrivateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return false
+	default:
+		return true
+	}
+}
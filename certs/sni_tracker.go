@@ -0,0 +1,211 @@
+package certs
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+const (
+	DefaultUnknownSNILimit = 1024
+
+	maxPendingSNIEvents = 64
+)
+
+// UnknownSNI is a server name requested by clients that no cert matched.
+type UnknownSNI struct {
+	ServerName string    `json:"serverName"`
+	Count      uint64    `json:"count"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// sniTracker counts unmatched server names in bounded memory. Once full, the
+// least requested name is replaced and the new name inherits its count, so
+// counts are upper bounds but the most requested names are kept. Names are
+// kept in a min-heap by count so a miss costs O(log limit).
+type sniTracker struct {
+	lock  sync.Mutex
+	limit int
+	names map[string]*sniEntry
+	heap  sniHeap
+}
+
+type sniEntry struct {
+	UnknownSNI
+	index int
+}
+
+func newSNITracker(limit int) *sniTracker {
+	if limit <= 0 {
+		limit = DefaultUnknownSNILimit
+	}
+	return &sniTracker{
+		limit: limit,
+		names: make(map[string]*sniEntry),
+	}
+}
+
+// observe records a miss, returning true the first time the name is tracked.
+func (t *sniTracker) observe(name string, now time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if entry, has := t.names[name]; has {
+		entry.Count++
+		entry.LastSeen = now
+		heap.Fix(&t.heap, entry.index)
+		return false
+	}
+	var count uint64
+	if len(t.names) >= t.limit {
+		min := heap.Pop(&t.heap).(*sniEntry)
+		delete(t.names, min.ServerName)
+		count = min.Count
+	}
+	entry := &sniEntry{UnknownSNI: UnknownSNI{
+		ServerName: name,
+		Count:      count + 1,
+		FirstSeen:  now,
+		LastSeen:   now,
+	}}
+	t.names[name] = entry
+	heap.Push(&t.heap, entry)
+	return true
+}
+
+// sniHeap orders entries by count, then least recently seen first.
+type sniHeap []*sniEntry
+
+func (h sniHeap) Len() int { return len(h) }
+
+func (h sniHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	return h[i].LastSeen.Before(h[j].LastSeen)
+}
+
+func (h sniHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *sniHeap) Push(x any) {
+	entry := x.(*sniEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *sniHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// top returns up to n names by descending count, or all names if n <= 0.
+func (t *sniTracker) top(n int) []UnknownSNI {
+	t.lock.Lock()
+	ret := make([]UnknownSNI, 0, len(t.names))
+	for _, entry := range t.names {
+		ret = append(ret, entry.UnknownSNI)
+	}
+	t.lock.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].LastSeen.After(ret[j].LastSeen)
+	})
+	if n > 0 && len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+func (t *sniTracker) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.names = make(map[string]*sniEntry)
+	t.heap = nil
+}
+
+// UnknownServerNames returns the n most requested server names that matched
+// no cert.
+func (r *Reloader) UnknownServerNames(n int) []UnknownSNI {
+	return r.unknownSNI.top(n)
+}
+
+func (r *Reloader) ResetUnknownServerNames() {
+	r.unknownSNI.reset()
+}
+
+func (r *Reloader) observeUnknownSNI(serverName string) {
+	serverName = NormalizeHostname(serverName)
+	now := r.now()
+	if !r.unknownSNI.observe(serverName, now) {
+		return
+	}
+	r.sniEvents.push(r, Event{
+		Type:    EventUnknownSNI,
+		Trigger: TriggerHandshake,
+		Name:    serverName,
+		Time:    now,
+	})
+}
+
+// sniEventQueue delivers unknown SNI events off the handshake path, as
+// clients control how many new names they send.
+type sniEventQueue struct {
+	lock    sync.Mutex
+	queue   []Event
+	sending bool
+	dropped int
+}
+
+// push queues the event, dropping it if maxPendingSNIEvents are waiting, and
+// starts delivering if nothing is.
+func (q *sniEventQueue) push(r *Reloader, e Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.queue) >= maxPendingSNIEvents {
+		q.dropped++
+		return
+	}
+	q.queue = append(q.queue, e)
+	if !q.sending {
+		q.sending = true
+		go q.deliver(r)
+	}
+}
+
+func (q *sniEventQueue) deliver(r *Reloader) {
+	for {
+		q.lock.Lock()
+		if len(q.queue) == 0 {
+			dropped := q.dropped
+			q.dropped = 0
+			q.sending = false
+			q.lock.Unlock()
+			if dropped > 0 {
+				logger.MaybeWarningf(r.Log, "Dropped %d unknown SNI events while handlers were busy", dropped)
+			}
+			return
+		}
+		e := q.queue[0]
+		q.queue = q.queue[1:]
+		q.lock.Unlock()
+		r.emit(e)
+	}
+}
+
+func (r *Reloader) emit(e Event) {
+	for _, h := range r.EventHandlers {
+		h(e)
+	}
+}
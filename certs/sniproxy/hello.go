@@ -0,0 +1,71 @@
+package sniproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	errHelloRead = errors.New("client hello read")
+)
+
+// peekClientHello reads the ClientHello from the conn and returns the
+// requested server name along with the bytes read, which must be replayed to
+// whoever handles the connection.
+func peekClientHello(conn net.Conn, timeout time.Duration) (string, []byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	var buf bytes.Buffer
+	var serverName string
+	var found bool
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			found = true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !found {
+		return "", nil, err
+	}
+	return serverName, buf.Bytes(), nil
+}
+
+// readOnlyConn lets the TLS server parse a ClientHello without writing
+// anything back to the client.
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// peekedConn replays the peeked bytes before reading from the conn.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newPeekedConn(conn net.Conn, peeked []byte) *peekedConn {
+	return &peekedConn{
+		Conn: conn,
+		r:    io.MultiReader(bytes.NewReader(peeked), conn),
+	}
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
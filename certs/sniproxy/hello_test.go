@@ -0,0 +1,114 @@
+package sniproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// peek runs peekClientHello on one end of a pipe with the client writing to
+// the other.
+func peek(t *testing.T, timeout time.Duration, client func(net.Conn)) (string, []byte, error) {
+	t.Helper()
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		client(conn)
+	}()
+	defer func() {
+		server.Close()
+		<-done
+	}()
+	return peekClientHello(server, timeout)
+}
+
+func write(data []byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		_, _ = conn.Write(data)
+	}
+}
+
+func TestPeekClientHello(t *testing.T) {
+	var hello bytes.Buffer
+	name, peeked, err := peek(t, time.Second, func(conn net.Conn) {
+		// record what the client sends, the handshake fails once the pipe
+		// closes
+		_ = tls.Client(teeConn{Conn: conn, w: &hello}, &tls.Config{ServerName: "web.test"}).Handshake()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "web.test" {
+		t.Errorf("server name = %q, want web.test", name)
+	}
+	if len(peeked) == 0 || !bytes.HasPrefix(hello.Bytes(), peeked) {
+		t.Fatalf("peeked %d bytes that are not the start of the client hello", len(peeked))
+	}
+
+	replayed := newPeekedConn(nopConn{r: bytes.NewReader([]byte("rest"))}, peeked)
+	data, err := io.ReadAll(replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]byte{}, peeked...), "rest"...); !bytes.Equal(data, want) {
+		t.Errorf("replayed %q, want the peeked bytes then the conn", data)
+	}
+}
+
+func TestPeekClientHelloNoServerName(t *testing.T) {
+	name, _, err := peek(t, time.Second, func(conn net.Conn) {
+		_ = tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(name) != 0 {
+		t.Errorf("server name = %q, want none", name)
+	}
+}
+
+func TestPeekClientHelloInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		client func(net.Conn)
+	}{
+		{name: "not tls", client: write([]byte("GET / HTTP/1.1\r\nHost: web.test\r\n\r\n"))},
+		{name: "truncated header", client: write([]byte{0x16, 0x03})},
+		{name: "truncated record", client: write(append([]byte{0x16, 0x03, 0x01, 0x00, 0xc8}, make([]byte, 10)...))},
+		{name: "oversized record", client: write([]byte{0x16, 0x03, 0x01, 0xff, 0xff})},
+		{name: "not a handshake", client: write([]byte{0x17, 0x03, 0x03, 0x00, 0x01, 0x00})},
+		{name: "silent", client: func(net.Conn) { time.Sleep(200 * time.Millisecond) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, peeked, err := peek(t, 50*time.Millisecond, tc.client); err == nil {
+				t.Fatalf("peeked %d bytes without an error", len(peeked))
+			}
+		})
+	}
+}
+
+// teeConn copies what is written to the conn.
+type teeConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c teeConn) Write(p []byte) (int, error) {
+	c.w.Write(p)
+	return c.Conn.Write(p)
+}
+
+// nopConn is a conn that only reads.
+type nopConn struct {
+	readOnlyConn
+	r io.Reader
+}
+
+func (c nopConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
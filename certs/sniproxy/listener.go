@@ -0,0 +1,56 @@
+package sniproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// localListener hands connections the proxy terminates locally to a server,
+// such as an http.Server, through Accept.
+type localListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newLocalListener() *localListener {
+	return &localListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *localListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *localListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *localListener) Addr() net.Addr {
+	return localAddr{}
+}
+
+type localAddr struct{}
+
+func (localAddr) Network() string { return "sniproxy" }
+func (localAddr) String() string  { return "local" }
+
+func (l *localListener) push(ctx context.Context, conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,35 @@
+package sniproxy
+
+import (
+	"time"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+type Option func(*Proxy)
+
+func OptLogger(log certs.Logger) Option {
+	return func(p *Proxy) {
+		p.Log = log
+	}
+}
+
+func OptDialTimeout(timeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.DialTimeout = timeout
+	}
+}
+
+func OptPeekTimeout(timeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.PeekTimeout = timeout
+	}
+}
+
+// OptFallback terminates TLS locally with certs from the reloader for server
+// names without a route. Terminated connections are accepted from Local.
+func OptFallback(r *certs.Reloader, opts ...certs.TLSConfigOption) Option {
+	return func(p *Proxy) {
+		p.TLSConfig = certs.NewServerTLSConfig(r, opts...)
+	}
+}
@@ -0,0 +1,93 @@
+package sniproxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/mat285/go-sdk/certs"
+	"gopkg.in/yaml.v3"
+)
+
+// Routes maps server names, which may be wildcards like *.example.com, to
+// backend addresses.
+type Routes struct {
+	Routes map[string]string `yaml:"routes" json:"routes"`
+}
+
+// LoadRoutes reads a YAML or JSON routing table.
+func LoadRoutes(path string) (*Routes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes Routes
+	if err := yaml.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parsing routes %s: %w", path, err)
+	}
+	normalized := make(map[string]string, len(routes.Routes))
+	for name, backend := range routes.Routes {
+		if len(backend) == 0 {
+			return nil, fmt.Errorf("parsing routes %s: no backend for %s", path, name)
+		}
+		name = certs.NormalizeHostname(name)
+		if err := certs.CheckWildcard(name); err != nil {
+			return nil, fmt.Errorf("parsing routes %s: %w", path, err)
+		}
+		normalized[name] = backend
+	}
+	routes.Routes = normalized
+	return &routes, nil
+}
+
+// Lookup returns the backend for the server name, matching exact names
+// before wildcards like the cert cache.
+func (r *Routes) Lookup(serverName string) (string, bool) {
+	if r == nil || len(serverName) == 0 {
+		return "", false
+	}
+	for _, name := range certs.SNICandidates(serverName) {
+		if backend, has := r.Routes[name]; has {
+			return backend, true
+		}
+	}
+	return "", false
+}
+
+// Table is a routing table that can be swapped while the proxy is serving.
+type Table struct {
+	Path   string
+	routes atomic.Pointer[Routes]
+}
+
+func NewTable(path string) *Table {
+	return &Table{Path: path}
+}
+
+func (t *Table) Routes() *Routes {
+	return t.routes.Load()
+}
+
+func (t *Table) Set(routes *Routes) {
+	t.routes.Store(routes)
+}
+
+func (t *Table) Lookup(serverName string) (string, bool) {
+	return t.Routes().Lookup(serverName)
+}
+
+// Load reads the table from its path, keeping the current routes on error.
+func (t *Table) Load(_ context.Context) error {
+	routes, err := LoadRoutes(t.Path)
+	if err != nil {
+		return err
+	}
+	t.Set(routes)
+	return nil
+}
+
+// ReloaderOption hot-reloads the table with the reloader's certs.
+func (t *Table) ReloaderOption() certs.ReloaderOption {
+	return certs.OptReloaderWatchFile(t.Path, t.Load)
+}
@@ -0,0 +1,95 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mat285/go-sdk/certs"
+)
+
+func writeRoutes(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRoutes(t *testing.T) {
+	routes, err := LoadRoutes(writeRoutes(t, "routes:\n  Web.Example.com.: web:443\n  '*.example.com': wildcard:443\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"web.example.com": "web:443", "*.example.com": "wildcard:443"}
+	if !reflect.DeepEqual(routes.Routes, want) {
+		t.Errorf("routes = %v, want %v", routes.Routes, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data string
+		err  error
+	}{
+		{name: "no backend", data: "routes:\n  web.example.com: ''\n"},
+		{name: "partial wildcard", data: "routes:\n  'w*.example.com': web:443\n", err: certs.ErrInvalidWildcard},
+		{name: "public suffix wildcard", data: "routes:\n  '*.co.uk': web:443\n", err: certs.ErrBroadWildcard},
+		{name: "not yaml", data: "routes: [\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadRoutes(writeRoutes(t, tc.data))
+			if err == nil || (tc.err != nil && !errors.Is(err, tc.err)) {
+				t.Errorf("LoadRoutes = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestRoutesLookup(t *testing.T) {
+	routes := &Routes{Routes: map[string]string{
+		"web.example.com": "web:443",
+		"*.example.com":   "wildcard:443",
+		"10.0.0.1":        "ip:443",
+	}}
+	for _, tc := range []struct {
+		serverName string
+		backend    string
+	}{
+		{serverName: "web.example.com", backend: "web:443"},
+		{serverName: "WEB.example.com.", backend: "web:443"},
+		{serverName: "api.example.com", backend: "wildcard:443"},
+		{serverName: "a.api.example.com"},
+		{serverName: "example.com"},
+		{serverName: "10.0.0.1", backend: "ip:443"},
+		{serverName: ""},
+	} {
+		backend, ok := routes.Lookup(tc.serverName)
+		if backend != tc.backend || ok != (len(tc.backend) > 0) {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", tc.serverName, backend, ok, tc.backend)
+		}
+	}
+	if _, ok := (*Routes)(nil).Lookup("web.example.com"); ok {
+		t.Error("nil routes matched")
+	}
+}
+
+func TestTableLoadKeepsRoutesOnError(t *testing.T) {
+	path := writeRoutes(t, "routes:\n  web.example.com: web:443\n")
+	table := NewTable(path)
+	if err := table.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("routes:\n  web.example.com: ''\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Load(context.Background()); err == nil {
+		t.Fatal("loaded an invalid table")
+	}
+	if backend, _ := table.Lookup("web.example.com"); backend != "web:443" {
+		t.Errorf("lookup after a failed load = %q, want web:443", backend)
+	}
+}
@@ -0,0 +1,150 @@
+package sniproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/blend/go-sdk/logger"
+	"github.com/mat285/go-sdk/certs"
+)
+
+const (
+	DefaultDialTimeout = 10 * time.Second
+	DefaultPeekTimeout = 5 * time.Second
+)
+
+// Proxy routes TLS connections to backends by the server name in the
+// ClientHello without terminating TLS. Connections without a route are
+// terminated locally when a fallback TLS config is set, and closed otherwise.
+type Proxy struct {
+	Log         certs.Logger
+	Table       *Table
+	TLSConfig   *tls.Config
+	DialTimeout time.Duration
+	PeekTimeout time.Duration
+
+	local *localListener
+}
+
+func New(table *Table, opts ...Option) *Proxy {
+	p := &Proxy{
+		Table: table,
+		local: newLocalListener(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Local returns the listener for connections terminated by the fallback.
+func (p *Proxy) Local() net.Listener {
+	return p.local
+}
+
+// Serve proxies connections from the listener until the context is cancelled
+// or accepting fails. It waits for open connections to close before
+// returning.
+func (p *Proxy) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, conn)
+		}()
+	}
+}
+
+func (p *Proxy) handle(ctx context.Context, conn net.Conn) {
+	serverName, peeked, err := peekClientHello(conn, p.peekTimeout())
+	if err != nil {
+		logger.MaybeDebugfContext(ctx, p.Log, "Error reading client hello from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	client := newPeekedConn(conn, peeked)
+	if backend, ok := p.Table.Lookup(serverName); ok {
+		p.passthrough(ctx, client, serverName, backend)
+		return
+	}
+	if p.TLSConfig != nil {
+		if !p.local.push(ctx, tls.Server(client, p.TLSConfig)) {
+			conn.Close()
+		}
+		return
+	}
+	logger.MaybeWarningfContext(ctx, p.Log, "No route for server name %q from %s", serverName, conn.RemoteAddr())
+	conn.Close()
+}
+
+func (p *Proxy) passthrough(ctx context.Context, client net.Conn, serverName, backend string) {
+	defer client.Close()
+	dialer := net.Dialer{Timeout: p.dialTimeout()}
+	upstream, err := dialer.DialContext(ctx, "tcp", backend)
+	if err != nil {
+		logger.MaybeErrorfContext(ctx, p.Log, "Error dialing backend %s for %s: %v", backend, serverName, err)
+		return
+	}
+	defer upstream.Close()
+	logger.MaybeDebugfContext(ctx, p.Log, "Proxying %s from %s to %s", serverName, client.RemoteAddr(), backend)
+
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pipe(upstream, client)
+	}()
+	pipe(client, upstream)
+	<-done
+}
+
+// pipe copies until EOF then half-closes the destination so the other
+// direction can finish.
+func pipe(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	if peeked, ok := dst.(*peekedConn); ok {
+		dst = peeked.Conn
+	}
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+		return
+	}
+	dst.Close()
+}
+
+func (p *Proxy) dialTimeout() time.Duration {
+	if p.DialTimeout > 0 {
+		return p.DialTimeout
+	}
+	return DefaultDialTimeout
+}
+
+func (p *Proxy) peekTimeout() time.Duration {
+	if p.PeekTimeout > 0 {
+		return p.PeekTimeout
+	}
+	return DefaultPeekTimeout
+}
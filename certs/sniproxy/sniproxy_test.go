@@ -0,0 +1,125 @@
+package sniproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mat285/go-sdk/certs/certstest"
+)
+
+// greet accepts connections from the listener until it is closed, writing
+// the greeting to each and closing it.
+func greet(t *testing.T, l net.Listener, greeting string) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, greeting)
+			conn.Close()
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+}
+
+// serve runs the proxy on a local port until the test ends.
+func serve(t *testing.T, p *Proxy) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve = %v, want %v", err, context.Canceled)
+		}
+	})
+	return l.Addr().String()
+}
+
+// dial connects to the proxy for the server name, verifying the server
+// against the CA, and returns what the server writes.
+func dial(t *testing.T, addr, serverName string, ca *certstest.CA) (string, error) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, RootCAs: ca.Pool()})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	return string(data), err
+}
+
+func TestProxy(t *testing.T) {
+	ca := certstest.NewCA(t)
+	backend, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, certstest.OptDNSNames("web.test", "api.example.test")).TLSCertificate()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	greet(t, backend, "backend")
+
+	dir := certstest.NewDir(t)
+	dir.WritePair("local", ca.Issue(t, certstest.OptDNSNames("local.test")))
+	r, _ := certstest.NewReloader(t, dir)
+
+	table := NewTable("")
+	table.Set(&Routes{Routes: map[string]string{
+		"web.test":       backend.Addr().String(),
+		"*.example.test": backend.Addr().String(),
+		"closed.test":    "127.0.0.1:1",
+	}})
+	p := New(table, OptFallback(r))
+	greet(t, p.Local(), "local")
+	addr := serve(t, p)
+
+	for _, tc := range []struct {
+		serverName string
+		greeting   string
+	}{
+		{serverName: "web.test", greeting: "backend"},
+		{serverName: "api.example.test", greeting: "backend"},
+		{serverName: "local.test", greeting: "local"},
+	} {
+		got, err := dial(t, addr, tc.serverName, ca)
+		if err != nil {
+			t.Errorf("dialing %s: %v", tc.serverName, err)
+			continue
+		}
+		if got != tc.greeting {
+			t.Errorf("%s greeted with %q, want %q", tc.serverName, got, tc.greeting)
+		}
+	}
+	// the fallback has no cert for an unrouted name, and a route to a closed
+	// port drops the connection
+	for _, serverName := range []string{"unrouted.test", "closed.test"} {
+		if got, err := dial(t, addr, serverName, ca); err == nil {
+			t.Errorf("%s greeted with %q, want an error", serverName, got)
+		}
+	}
+}
+
+func TestProxyWithoutFallback(t *testing.T) {
+	ca := certstest.NewCA(t)
+	p := New(NewTable(""))
+	addr := serve(t, p)
+	if got, err := dial(t, addr, "web.test", ca); err == nil {
+		t.Errorf("unrouted name greeted with %q, want an error", got)
+	}
+}
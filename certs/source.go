@@ -0,0 +1,102 @@
+package certs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+// Source supplies certs from somewhere other than the reloader's directories,
+// such as an API. Its certs are staged, pinned and ordered by precedence like
+// pairs on disk, but only the source evicts them.
+type Source interface {
+	// Name identifies the source's certs, which should be named so they
+	// cannot collide with pair names.
+	Name() string
+	// Load sets the source's current certs when the reloader initializes.
+	Load(ctx context.Context, sink *SourceSink) error
+	// Watch applies changes to the certs until the context is cancelled.
+	Watch(ctx context.Context, sink *SourceSink) error
+}
+
+// SourceSink applies a source's certs to the reloader's cache.
+type SourceSink struct {
+	source string
+	cache  *Cache
+	loader *Loader
+}
+
+// Loader returns the reloader's loader, for parsing certs with its policy and
+// passphrase provider.
+func (s *SourceSink) Loader() *Loader {
+	return s.loader
+}
+
+func (s *SourceSink) Set(certs ...*Cert) {
+	for _, cert := range certs {
+		if cert != nil {
+			cert.Source = s.source
+		}
+	}
+	s.cache.setWithTrigger(TriggerSource, certs...)
+}
+
+// Evict removes a cert set by the source.
+func (s *SourceSink) Evict(name string) {
+	s.cache.lock.Lock()
+	defer s.cache.unlock()
+	if cert := s.cache.latest(name); cert != nil && cert.Source == s.source {
+		s.cache.evict(name, TriggerSource)
+	}
+}
+
+// Replace evicts the source's certs not named in present and sets the certs,
+// for when the source has read all of its certs. Entries that failed to parse
+// should be named in present to keep serving their previous certs, as pairs
+// on disk are.
+func (s *SourceSink) Replace(present []string, certs ...*Cert) {
+	keep := make(map[string]bool, len(present))
+	for _, name := range present {
+		keep[name] = true
+	}
+	for _, cert := range certs {
+		if cert != nil {
+			cert.Source = s.source
+			keep[cert.Name] = true
+		}
+	}
+	c := s.cache
+	c.lock.Lock()
+	defer c.unlock()
+	for _, owned := range []map[string]*Cert{c.certs, c.staged} {
+		for name, cert := range owned {
+			if cert.Source == s.source && !keep[name] {
+				c.evict(name, TriggerSource)
+			}
+		}
+	}
+	c.set(TriggerSource, certs...)
+}
+
+func (r *Reloader) sink(src Source) *SourceSink {
+	return &SourceSink{source: src.Name(), cache: r.certs, loader: &r.Loader}
+}
+
+func (r *Reloader) initializeSources(ctx context.Context) error {
+	errs := make([]error, 0, len(r.Sources))
+	for _, src := range r.Sources {
+		if err := src.Load(ctx, r.sink(src)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Reloader) watchSource(ctx context.Context, src Source) {
+	logger.MaybeInfofContext(ctx, r.Log, "Watching cert source %s", src.Name())
+	err := src.Watch(ctx, r.sink(src))
+	if err != nil && ctx.Err() == nil {
+		logger.MaybeErrorfContext(ctx, r.Log, "Cert source %s stopped: %v", src.Name(), err)
+	}
+}
@@ -0,0 +1,238 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+var (
+	ErrNoSPIFFEID          = errors.New("certificate has no SPIFFE ID")
+	ErrSPIFFEUnauthorized  = errors.New("peer SPIFFE ID is not authorized")
+	ErrUnknownTrustDomain  = errors.New("no trust bundle for trust domain")
+	ErrNoPeerCertificates  = errors.New("peer presented no certificates")
+	ErrNoSVID              = errors.New("no X.509-SVID loaded")
+	errInvalidSPIFFEID     = errors.New("invalid SPIFFE ID")
+	errSPIFFEBundleNoCerts = errors.New("no x509-svid keys in bundle")
+)
+
+// ParseSPIFFEID validates a SPIFFE ID and returns its trust domain.
+func ParseSPIFFEID(id string) (string, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", errInvalidSPIFFEID, id, err)
+	}
+	if u.Scheme != "spiffe" || len(u.Host) == 0 || u.User != nil || len(u.Port()) > 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return "", fmt.Errorf("%w %q", errInvalidSPIFFEID, id)
+	}
+	return strings.ToLower(u.Host), nil
+}
+
+// SPIFFEIDOf returns the SPIFFE ID of an X.509-SVID, which must carry exactly
+// one URI SAN.
+func SPIFFEIDOf(cert *x509.Certificate) (string, error) {
+	if cert == nil || len(cert.URIs) != 1 {
+		return "", ErrNoSPIFFEID
+	}
+	id := cert.URIs[0].String()
+	if _, err := ParseSPIFFEID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (c *Cert) SPIFFEID() string {
+	if c == nil || c.Leaf == nil {
+		return ""
+	}
+	id, err := SPIFFEIDOf(c.Leaf)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// MatchSPIFFEID reports whether the id matches any of the patterns. Patterns
+// use path.Match syntax, so `*` matches a single path segment.
+func MatchSPIFFEID(id string, patterns ...string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type spiffeBundle struct {
+	Keys []struct {
+		Use string   `json:"use"`
+		X5C []string `json:"x5c"`
+	} `json:"keys"`
+}
+
+// LoadSPIFFEBundle reads a trust bundle in the SPIFFE JSON bundle format or as
+// PEM or DER certificates.
+func LoadSPIFFEBundle(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var bundle spiffeBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return LoadTrustPool(file)
+	}
+	pool := x509.NewCertPool()
+	for _, key := range bundle.Keys {
+		if key.Use != "x509-svid" {
+			continue
+		}
+		for _, enc := range key.X5C {
+			der, err := base64.StdEncoding.DecodeString(enc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			pool.AddCert(cert)
+		}
+	}
+	if pool.Equal(x509.NewCertPool()) {
+		return nil, fmt.Errorf("%s: %w", file, errSPIFFEBundleNoCerts)
+	}
+	return pool, nil
+}
+
+func (r *Reloader) loadSPIFFEBundles(ctx context.Context) error {
+	bundles := make(map[string]*x509.CertPool, len(r.SPIFFEBundles))
+	for td, file := range r.SPIFFEBundles {
+		pool, err := LoadSPIFFEBundle(file)
+		if err != nil {
+			return err
+		}
+		bundles[strings.ToLower(td)] = pool
+	}
+	logger.MaybeDebugfContext(ctx, r.Log, "Loaded %d SPIFFE trust bundles", len(bundles))
+	r.bundles.Store(&bundles)
+	return nil
+}
+
+// SPIFFEBundle returns the trust bundle for a trust domain.
+func (r *Reloader) SPIFFEBundle(trustDomain string) *x509.CertPool {
+	bundles := r.bundles.Load()
+	if bundles == nil {
+		return nil
+	}
+	return (*bundles)[strings.ToLower(trustDomain)]
+}
+
+// VerifySPIFFEPeer returns a VerifyConnection func that verifies the peer
+// SVID against the trust bundle of its trust domain and authorizes its SPIFFE
+// ID against the patterns. It runs on resumed sessions too, so they are
+// checked against the current bundles and, with CRL files, the current CRLs.
+func (r *Reloader) VerifySPIFFEPeer(patterns ...string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return ErrNoPeerCertificates
+		}
+		id, chains, err := r.verifySVID(cs.PeerCertificates)
+		if err != nil {
+			return err
+		}
+		if len(r.CRLFiles) > 0 {
+			if err := r.checkCRLs(chains); err != nil {
+				return err
+			}
+		}
+		if !MatchSPIFFEID(id, patterns...) {
+			return fmt.Errorf("%w: %s", ErrSPIFFEUnauthorized, id)
+		}
+		return nil
+	}
+}
+
+func (r *Reloader) verifySVID(certs []*x509.Certificate) (string, [][]*x509.Certificate, error) {
+	leaf := certs[0]
+	id, err := SPIFFEIDOf(leaf)
+	if err != nil {
+		return "", nil, err
+	}
+	if leaf.IsCA {
+		return "", nil, fmt.Errorf("SVID %s is a CA certificate", id)
+	}
+	td, _ := ParseSPIFFEID(id)
+	roots := r.SPIFFEBundle(td)
+	if roots == nil {
+		return "", nil, fmt.Errorf("%w %s", ErrUnknownTrustDomain, td)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   r.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, chains, nil
+}
+
+// GetSVID returns the SVID for the configured SPIFFE ID, or any loaded SVID
+// if none is configured.
+func (r *Reloader) GetSVID() (*tls.Certificate, error) {
+	if len(r.SPIFFEID) > 0 {
+		cert := r.certs.GetSPIFFE(r.SPIFFEID)
+		if cert == nil {
+			return nil, fmt.Errorf("%w for %s", ErrNoSVID, r.SPIFFEID)
+		}
+		return &cert.Certificate, nil
+	}
+	for _, cert := range r.certs.All() {
+		if len(cert.SPIFFEID()) > 0 {
+			return &cert.Certificate, nil
+		}
+	}
+	return nil, ErrNoSVID
+}
+
+// NewSPIFFEServerTLSConfig returns a server config that presents the
+// reloader's SVID and requires clients to present an SVID matching one of the
+// patterns, checking their chains against the reloader's CRLs.
+func NewSPIFFEServerTLSConfig(r *Reloader, patterns ...string) *tls.Config {
+	cfg := hardenedTLSConfig()
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.GetSVID()
+	}
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	cfg.VerifyConnection = r.VerifySPIFFEPeer(patterns...)
+	return cfg
+}
+
+// NewSPIFFEClientTLSConfig returns a client config that presents the
+// reloader's SVID and authorizes servers by SPIFFE ID rather than hostname.
+func NewSPIFFEClientTLSConfig(r *Reloader, patterns ...string) *tls.Config {
+	cfg := hardenedTLSConfig()
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return r.GetSVID()
+	}
+	// hostname verification does not apply to SVIDs, the chain is verified
+	// against the trust bundle in VerifyConnection
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = r.VerifySPIFFEPeer(patterns...)
+	return cfg
+}
@@ -0,0 +1,117 @@
+package certs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Status is a snapshot of the certs a reloader is serving.
+type Status struct {
+	Certs []CertStatus `json:"certs"`
+}
+
+type CertStatus struct {
+	Name        string    `json:"name"`
+	Source      string    `json:"source,omitempty"`
+	Hostnames   []string  `json:"hostnames"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+	Version     int       `json:"version"`
+	Versions    []int     `json:"versions"`
+	Pinned      bool      `json:"pinned"`
+	Staged      bool      `json:"staged"`
+}
+
+func (r *Reloader) Status() Status {
+	c := r.certs
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	status := Status{Certs: make([]CertStatus, 0, len(c.certs))}
+	for name, cert := range c.certs {
+		cs := CertStatus{
+			Name:        name,
+			Source:      cert.Source,
+			Hostnames:   cert.Hostnames(),
+			Fingerprint: cert.Fingerprint(),
+			Version:     c.servedVersion(name),
+			Staged:      c.staged[name] != nil,
+		}
+		if cert.Leaf != nil {
+			cs.NotAfter = cert.Leaf.NotAfter
+		}
+		_, cs.Pinned = c.pinned[name]
+		for _, v := range c.history[name] {
+			cs.Versions = append(cs.Versions, v.Version)
+		}
+		status.Certs = append(status.Certs, cs)
+	}
+	sort.Slice(status.Certs, func(i, j int) bool {
+		return status.Certs[i].Name < status.Certs[j].Name
+	})
+	return status
+}
+
+func (r *Reloader) History(name string) []CertVersion {
+	return r.certs.History(name)
+}
+
+func (r *Reloader) Pin(name string, version int) error {
+	return r.certs.Pin(name, version)
+}
+
+func (r *Reloader) Unpin(name string) error {
+	return r.certs.Unpin(name)
+}
+
+func (r *Reloader) Rollback(name string) (int, error) {
+	return r.certs.Rollback(name)
+}
+
+// AdminHandler serves the reloader status on GET and pins, unpins or rolls
+// back the cert in the name query parameter on POST to /pin?version=N,
+// /unpin and /rollback. It should only be exposed to operators.
+func (r *Reloader) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+	mux.HandleFunc("POST /pin", func(w http.ResponseWriter, req *http.Request) {
+		version, err := strconv.Atoi(req.URL.Query().Get("version"))
+		if err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		writeAdminResult(w, r.Pin(req.URL.Query().Get("name"), version), r.Status())
+	})
+	mux.HandleFunc("POST /unpin", func(w http.ResponseWriter, req *http.Request) {
+		writeAdminResult(w, r.Unpin(req.URL.Query().Get("name")), r.Status())
+	})
+	mux.HandleFunc("POST /rollback", func(w http.ResponseWriter, req *http.Request) {
+		_, err := r.Rollback(req.URL.Query().Get("name"))
+		writeAdminResult(w, err, r.Status())
+	})
+	return mux
+}
+
+func writeAdminResult(w http.ResponseWriter, err error, status Status) {
+	switch {
+	case errors.Is(err, ErrUnknownCert):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNoPreviousVersion):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
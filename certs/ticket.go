@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+const (
+	maxTicketKeys = 4
+)
+
+// LoadTicketKeys reads session ticket keys from a file with one base64
+// encoded 32 byte key per line, current key first.
+func LoadTicketKeys(file string) ([][32]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys [][32]byte
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", file, i+1, err)
+		}
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("%s line %d: ticket keys must be 32 bytes, got %d", file, i+1, len(raw))
+		}
+		var key [32]byte
+		copy(key[:], raw)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no ticket keys", file)
+	}
+	return keys, nil
+}
+
+func encodeTicketKeys(keys [][32]byte) []byte {
+	var buf bytes.Buffer
+	for _, key := range keys {
+		buf.WriteString(base64.StdEncoding.EncodeToString(key[:]))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// SessionTicketKeys returns the current ticket keys, current key first.
+func (r *Reloader) SessionTicketKeys() [][32]byte {
+	r.ticketLock.Lock()
+	defer r.ticketLock.Unlock()
+	return append([][32]byte(nil), r.ticketKeys...)
+}
+
+// RegisterTLSConfig keeps the config's session ticket keys in sync with the
+// reloader's ticket keys.
+func (r *Reloader) RegisterTLSConfig(cfg *tls.Config) {
+	r.ticketLock.Lock()
+	defer r.ticketLock.Unlock()
+	r.ticketConfigs = append(r.ticketConfigs, cfg)
+	if len(r.ticketKeys) > 0 {
+		cfg.SetSessionTicketKeys(r.ticketKeys)
+	}
+}
+
+// setTicketKeys makes keys[0] the current key, keeping the previous current
+// key so tickets issued before the change can still be resumed.
+func (r *Reloader) setTicketKeys(keys [][32]byte) {
+	r.ticketLock.Lock()
+	defer r.ticketLock.Unlock()
+	merged := append([][32]byte(nil), keys...)
+	for _, prev := range r.ticketKeys {
+		if !containsTicketKey(merged, prev) {
+			merged = append(merged, prev)
+			break
+		}
+	}
+	if len(merged) > maxTicketKeys {
+		merged = merged[:maxTicketKeys]
+	}
+	r.ticketKeys = merged
+	r.ticketGen.Add(1)
+	for _, cfg := range r.ticketConfigs {
+		cfg.SetSessionTicketKeys(merged)
+	}
+}
+
+func containsTicketKey(keys [][32]byte, key [32]byte) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func ticketKeysEqual(a, b [][32]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadTicketKeys applies the keys in the ticket key file when any of them
+// changed. When rotating, a missing file is created on the first rotation.
+func (r *Reloader) loadTicketKeys(ctx context.Context) error {
+	keys, err := LoadTicketKeys(r.TicketKeyFile)
+	if err != nil {
+		if r.TicketKeyRotation > 0 && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	r.ticketLock.Lock()
+	unchanged := ticketKeysEqual(keys, r.ticketFile)
+	r.ticketFile = keys
+	r.ticketLock.Unlock()
+	if unchanged {
+		return nil
+	}
+	logger.MaybeInfofContext(ctx, r.Log, "Loaded %d session ticket keys from %s", len(keys), r.TicketKeyFile)
+	r.setTicketKeys(keys)
+	return nil
+}
+
+// rotateTicketKeys generates a new current key, writing it to the ticket key
+// file when one is configured so other servers sharing the file pick it up.
+// Keys in the file that were not loaded yet are kept, so servers rotating at
+// the same time drop fewer of each other's keys.
+func (r *Reloader) rotateTicketKeys(ctx context.Context) error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	keys := [][32]byte{key}
+	var shared [][32]byte
+	if len(r.TicketKeyFile) > 0 {
+		if fileKeys, err := LoadTicketKeys(r.TicketKeyFile); err == nil {
+			shared = fileKeys
+		}
+	}
+	for _, k := range append(shared, r.SessionTicketKeys()...) {
+		if !containsTicketKey(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > maxTicketKeys {
+		keys = keys[:maxTicketKeys]
+	}
+	if len(r.TicketKeyFile) > 0 {
+		if err := writeFileAtomic(r.TicketKeyFile, encodeTicketKeys(keys), 0600); err != nil {
+			return err
+		}
+		r.ticketLock.Lock()
+		r.ticketFile = keys
+		r.ticketLock.Unlock()
+	}
+	logger.MaybeInfofContext(ctx, r.Log, "Rotated session ticket keys")
+	r.setTicketKeys(keys)
+	return nil
+}
+
+// initializeTicketKeys generates the first key when rotating, unless keys
+// from before a restart or from other servers were loaded from the file.
+func (r *Reloader) initializeTicketKeys(ctx context.Context) error {
+	if r.TicketKeyRotation <= 0 || len(r.SessionTicketKeys()) > 0 {
+		return nil
+	}
+	return r.rotateTicketKeys(ctx)
+}
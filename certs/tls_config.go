@@ -0,0 +1,168 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNoClientCert = errors.New("no client cert")
+)
+
+type TLSConfigOption func(*tls.Config)
+
+func OptTLSMinVersion(version uint16) TLSConfigOption {
+	return func(cfg *tls.Config) {
+		cfg.MinVersion = version
+	}
+}
+
+func OptTLSNextProtos(protos ...string) TLSConfigOption {
+	return func(cfg *tls.Config) {
+		cfg.NextProtos = protos
+	}
+}
+
+func OptTLSClientAuth(auth tls.ClientAuthType) TLSConfigOption {
+	return func(cfg *tls.Config) {
+		cfg.ClientAuth = auth
+	}
+}
+
+// NewServerTLSConfig returns a hardened server config that serves
+// certificates from the reloader and verifies client certificates against
+// its trust pool and CRLs, applying host policies when a policy file is
+// configured.
+func NewServerTLSConfig(r *Reloader, opts ...TLSConfigOption) *tls.Config {
+	base := hardenedTLSConfig()
+	base.NextProtos = []string{"h2", "http/1.1"}
+	base.GetCertificate = r.GetCertificate
+	if len(r.CRLFiles) > 0 {
+		base.VerifyConnection = r.VerifyCRL
+	}
+	for _, opt := range opts {
+		opt(base)
+	}
+	cfg := base.Clone()
+	cfg.GetConfigForClient = r.ConfigForClient(base)
+	r.RegisterTLSConfig(cfg)
+	return cfg
+}
+
+// NewClientTLSConfig returns a hardened client config that presents client
+// certificates from the reloader and, when trust files are configured,
+// verifies servers against the reloader's current trust pool instead of the
+// system roots. Servers are checked against the pin sets when a pin file is
+// configured.
+func NewClientTLSConfig(r *Reloader, opts ...TLSConfigOption) *tls.Config {
+	cfg := hardenedTLSConfig()
+	cfg.GetClientCertificate = r.GetClientCertificate
+	switch {
+	case len(r.TrustFiles) > 0:
+		// verification is done in VerifyConnection so the pool can be swapped
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = r.verifyServer
+	case len(r.PinFile) > 0:
+		cfg.VerifyConnection = r.VerifyPins
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func hardenedTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+		},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// GetClientCertificate presents the ClientCert when one is configured.
+// Otherwise it chooses among the certs the server accepts, preferring certs
+// with the client auth usage to those with no usage restrictions and never
+// choosing server-only certs, then by precedence so the choice is stable.
+func (r *Reloader) GetClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if len(r.ClientCert) > 0 {
+		cert := r.getClientCert()
+		if cert == nil {
+			return nil, fmt.Errorf("%w %s", ErrNoClientCert, r.ClientCert)
+		}
+		return &cert.Certificate, nil
+	}
+	var best *Cert
+	var bestRank int
+	for _, cert := range r.certs.All() {
+		rank := clientAuthRank(cert)
+		if rank == 0 || cert.Metadata.IsDisabled() || cri.SupportsCertificate(&cert.Certificate) != nil {
+			continue
+		}
+		if best == nil || rank > bestRank || (rank == bestRank && precedes(cert, best)) {
+			best, bestRank = cert, rank
+		}
+	}
+	if best != nil {
+		return &best.Certificate, nil
+	}
+	// an empty certificate lets the server decide whether one is required
+	return &tls.Certificate{}, nil
+}
+
+func (r *Reloader) getClientCert() *Cert {
+	if strings.HasPrefix(r.ClientCert, "spiffe://") {
+		return r.certs.GetSPIFFE(r.ClientCert)
+	}
+	return r.certs.Get(pairName(r.ClientCert))
+}
+
+// clientAuthRank is 2 for certs with the client auth usage, 1 for certs with
+// no usage restrictions and 0 for certs that cannot authenticate clients.
+func clientAuthRank(cert *Cert) int {
+	if cert.Leaf == nil {
+		return 0
+	}
+	if len(cert.Leaf.ExtKeyUsage) == 0 && len(cert.Leaf.UnknownExtKeyUsage) == 0 {
+		return 1
+	}
+	for _, usage := range cert.Leaf.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+			return 2
+		}
+	}
+	return 0
+}
+
+func (r *Reloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificates")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         r.TrustPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   r.now(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := cs.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+	return r.checkPins(cs.ServerName, chains, cs.PeerCertificates)
+}
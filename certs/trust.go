@@ -0,0 +1,44 @@
+package certs
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+// LoadTrustPool reads PEM or DER certificate bundles into a pool.
+func LoadTrustPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		data, err := readCertPEM(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", file)
+		}
+	}
+	return pool, nil
+}
+
+// TrustPool returns the pool built from the reloader's trust files, or nil if
+// none are configured.
+func (r *Reloader) TrustPool() *x509.CertPool {
+	return r.trust.Load()
+}
+
+func (r *Reloader) loadTrustPool(ctx context.Context) error {
+	if len(r.TrustFiles) == 0 {
+		return errors.New("no trust files configured")
+	}
+	pool, err := LoadTrustPool(r.TrustFiles...)
+	if err != nil {
+		return err
+	}
+	logger.MaybeDebugfContext(ctx, r.Log, "Loaded trust pool from %v", r.TrustFiles)
+	r.trust.Store(pool)
+	return nil
+}
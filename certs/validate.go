@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Policy is a set of rules certificates must satisfy to be loaded. Zero
+// fields are not enforced.
+type Policy struct {
+	MinRSABits                   int           `json:"minRSABits" yaml:"minRSABits"`
+	MinECDSABits                 int           `json:"minECDSABits" yaml:"minECDSABits"`
+	ForbidSHA1                   bool          `json:"forbidSHA1" yaml:"forbidSHA1"`
+	ForbiddenSignatureAlgorithms []string      `json:"forbiddenSignatureAlgorithms" yaml:"forbiddenSignatureAlgorithms"`
+	MaxValidity                  time.Duration `json:"maxValidity" yaml:"maxValidity"`
+	RequiredExtKeyUsages         []string      `json:"requiredExtKeyUsages" yaml:"requiredExtKeyUsages"`
+	ForbiddenSANs                []string      `json:"forbiddenSANs" yaml:"forbiddenSANs"`
+}
+
+// PolicyViolationError lists every rule a certificate pair violated.
+type PolicyViolationError struct {
+	Name       string
+	Violations []string
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("cert %s violates policy: %s", e.Name, strings.Join(e.Violations, "; "))
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+var sha1SignatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.MD5WithRSA,
+	x509.SHA1WithRSA,
+	x509.DSAWithSHA1,
+	x509.ECDSAWithSHA1,
+}
+
+// Validate checks the policy's own settings.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, name := range p.RequiredExtKeyUsages {
+		if _, has := extKeyUsageNames[name]; !has {
+			return fmt.Errorf("unknown extended key usage %q", name)
+		}
+	}
+	for _, pattern := range p.ForbiddenSANs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid forbidden SAN pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Check returns a *PolicyViolationError if the chain, leaf first, violates the
+// policy.
+func (p *Policy) Check(name string, chain []*x509.Certificate) error {
+	if p == nil || len(chain) == 0 {
+		return nil
+	}
+	leaf := chain[0]
+	var violations []string
+	switch key := leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if p.MinRSABits > 0 && key.N.BitLen() < p.MinRSABits {
+			violations = append(violations, fmt.Sprintf("RSA key is %d bits, minimum is %d", key.N.BitLen(), p.MinRSABits))
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; p.MinECDSABits > 0 && bits < p.MinECDSABits {
+			violations = append(violations, fmt.Sprintf("ECDSA key is %d bits, minimum is %d", bits, p.MinECDSABits))
+		}
+	}
+	for _, cert := range chain {
+		if cert.IsCA && isSelfSigned(cert) {
+			continue
+		}
+		if p.forbidsSignature(cert.SignatureAlgorithm) {
+			violations = append(violations, fmt.Sprintf("%s is signed with forbidden algorithm %s", cert.Subject, cert.SignatureAlgorithm))
+		}
+	}
+	if validity := leaf.NotAfter.Sub(leaf.NotBefore); p.MaxValidity > 0 && validity > p.MaxValidity {
+		violations = append(violations, fmt.Sprintf("validity %v exceeds maximum %v", validity, p.MaxValidity))
+	}
+	for _, name := range p.RequiredExtKeyUsages {
+		if !hasExtKeyUsage(leaf, extKeyUsageNames[name]) {
+			violations = append(violations, fmt.Sprintf("missing extended key usage %s", name))
+		}
+	}
+	// the common name is checked too since the cache indexes it like a SAN
+	names := sansOf(leaf)
+	if len(leaf.Subject.CommonName) > 0 {
+		names = append(names, leaf.Subject.CommonName)
+	}
+	for _, san := range names {
+		for _, pattern := range p.ForbiddenSANs {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(san)); ok {
+				violations = append(violations, fmt.Sprintf("SAN %s is forbidden", san))
+				break
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyViolationError{Name: name, Violations: violations}
+}
+
+func (p *Policy) forbidsSignature(alg x509.SignatureAlgorithm) bool {
+	if p.ForbidSHA1 {
+		for _, forbidden := range sha1SignatureAlgorithms {
+			if alg == forbidden {
+				return true
+			}
+		}
+	}
+	for _, name := range p.ForbiddenSignatureAlgorithms {
+		if strings.EqualFold(name, alg.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	// a certificate without the extension is valid for any usage
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return true
+	}
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func sansOf(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses)+len(cert.URIs)+len(cert.EmailAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	return sans
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(cert) == nil
+}
@@ -0,0 +1,105 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/blend/go-sdk/logger"
+)
+
+// WatchedFile is an auxiliary file, such as a policy file, that is reloaded
+// alongside the certs on interval and on change.
+type WatchedFile struct {
+	Path string
+	Load func(context.Context) error
+}
+
+func (r *Reloader) registerFiles() error {
+	if len(r.ConfigFile) > 0 {
+		if err := r.addWatchedFile(r.ConfigFile, r.loadConfig); err != nil {
+			return err
+		}
+	}
+	if len(r.PolicyFile) > 0 {
+		if err := r.addWatchedFile(r.PolicyFile, r.loadPolicies); err != nil {
+			return err
+		}
+	}
+	for _, file := range r.TrustFiles {
+		if err := r.addWatchedFile(file, r.loadTrustPool); err != nil {
+			return err
+		}
+	}
+	for _, file := range r.SPIFFEBundles {
+		if err := r.addWatchedFile(file, r.loadSPIFFEBundles); err != nil {
+			return err
+		}
+	}
+	for _, file := range r.CRLFiles {
+		if err := r.addWatchedFile(file, r.loadCRLs); err != nil {
+			return err
+		}
+	}
+	if len(r.PinFile) > 0 {
+		if err := r.addWatchedFile(r.PinFile, r.loadPinSets); err != nil {
+			return err
+		}
+	}
+	if len(r.TicketKeyFile) > 0 {
+		if err := r.addWatchedFile(r.TicketKeyFile, r.loadTicketKeys); err != nil {
+			return err
+		}
+	}
+	for _, f := range r.WatchFiles {
+		if err := r.addWatchedFile(f.Path, f.Load); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reloader) addWatchedFile(path string, load func(context.Context) error) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	r.files = append(r.files, &WatchedFile{Path: abs, Load: load})
+	return nil
+}
+
+func (r *Reloader) watchedFile(path string) *WatchedFile {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	for _, f := range r.files {
+		if f.Path == abs {
+			return f
+		}
+	}
+	return nil
+}
+
+func (r *Reloader) initializeFiles(ctx context.Context) error {
+	errs := make([]error, 0, len(r.files))
+	for _, f := range r.files {
+		if err := f.Load(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Reloader) reloadFiles(ctx context.Context) {
+	for _, f := range r.files {
+		r.reloadFile(ctx, f)
+	}
+}
+
+func (r *Reloader) reloadFile(ctx context.Context, f *WatchedFile) {
+	logger.MaybeDebugfContext(ctx, r.Log, "Reloading file %s", f.Path)
+	if err := f.Load(ctx); err != nil {
+		logger.MaybeErrorfContext(ctx, r.Log, "Error reloading file %s: %v", f.Path, err)
+	}
+}